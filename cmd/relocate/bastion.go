@@ -0,0 +1,65 @@
+package main
+
+import (
+	"cmp"
+	"strconv"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// jumpPath is the bastion a connection goes through
+type jumpPath struct {
+	name string // bastion name, or its instance name
+	spec string // user@host[:port]
+}
+
+// selectBastion picks the first configured bastion that applies to target.
+// A bastion applies when the target has no public IP, or lives in one of the
+// bastion's vpc_ids, and the bastion serves the target's VPC. Tag bastions are
+// looked up in inventory, the instances already loaded by discovery, and must
+// be in the target's VPC. ok is false when the target is reached directly.
+func selectBastion(bastions []config.Bastion, inventory []EC2Instance, target EC2Instance, user string) (path jumpPath, ok bool) {
+	for _, b := range bastions {
+		if !b.Serves(target.VpcID) || (target.PublicIP != "" && !b.Required(target.VpcID)) {
+			continue
+		}
+
+		name, host := b.Name, b.Host
+		if host == "" {
+			inst, found := findBastionInstance(b, inventory, target)
+			if !found {
+				continue
+			}
+			name = cmp.Or(name, inst.Name, inst.ID)
+			host = inst.IP
+		}
+
+		spec := cmp.Or(b.User, user) + "@" + host
+		if b.Port != 0 && b.Port != config.DefaultSSHPort {
+			spec += ":" + strconv.Itoa(b.Port)
+		}
+		return jumpPath{name: cmp.Or(name, host), spec: spec}, true
+	}
+	return jumpPath{}, false
+}
+
+// findBastionInstance finds a running instance matching the bastion's tags
+// in the target's VPC, preferring one with a public IP
+func findBastionInstance(b config.Bastion, inventory []EC2Instance, target EC2Instance) (EC2Instance, bool) {
+	var found EC2Instance
+	for _, inst := range inventory {
+		if inst.ID == target.ID || inst.VpcID != target.VpcID || inst.State != "running" || inst.IP == "" {
+			continue
+		}
+		if !b.MatchesInstance(inst.Tags) {
+			continue
+		}
+		if inst.PublicIP != "" {
+			return inst, true
+		}
+		if found.ID == "" {
+			found = inst
+		}
+	}
+	return found, found.ID != ""
+}
@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// broadcastOutputLimit caps the lines kept per instance; older lines are
+// dropped
+const broadcastOutputLimit = 10000
+
+// remoteTarget is an instance connected to without the confirm dialog, by a
+// broadcast command or a tmux pane, with its connection resolved up front
+type remoteTarget struct {
+	inst      EC2Instance
+	conn      config.Connection
+	transport string
+}
+
+// broadcastResult is the outcome of a broadcast command on one instance
+type broadcastResult struct {
+	inst      EC2Instance
+	transport string
+	output    []string
+	done      bool
+	exitCode  int   // -1 if the command could not be run
+	err       error // why the command could not be run, or failed
+	duration  time.Duration
+}
+
+// broadcastRun is a command running on several instances
+type broadcastRun struct {
+	command string
+	started time.Time
+	results []broadcastResult
+	cursor  int // result shown in the output pane
+	running bool
+	stream  <-chan tea.Msg
+	cancel  context.CancelFunc
+}
+
+// Broadcast messages. Output lines and results are streamed per instance;
+// the run ends with a broadcastFinishedMsg.
+type broadcastOutputMsg struct {
+	idx    int
+	line   string
+	stream <-chan tea.Msg
+}
+
+type broadcastDoneMsg struct {
+	idx      int
+	exitCode int
+	err      error
+	duration time.Duration
+	stream   <-chan tea.Msg
+}
+
+type broadcastFinishedMsg struct {
+	stream <-chan tea.Msg
+}
+
+// waitForBroadcast returns a command that reads the next message of a
+// broadcast run. A closed stream, left by a cancelled run, reads as finished.
+func waitForBroadcast(stream <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-stream
+		if !ok {
+			return broadcastFinishedMsg{stream: stream}
+		}
+		return msg
+	}
+}
+
+// runBroadcast runs command on every target, at most parallelism at a time,
+// each bounded by timeout unless it is 0. The native engine runs it with the
+// built-in SSH client, sharing bastion connections between targets. Progress
+// is sent to stream until ctx is cancelled, and stream is closed on return.
+func runBroadcast(ctx context.Context, targets []remoteTarget, command, engine string, parallelism int, timeout time.Duration, stream chan tea.Msg) {
+	defer close(stream)
+	send := func(msg tea.Msg) {
+		select {
+		case stream <- msg:
+		case <-ctx.Done():
+		}
+	}
+
+	var dialer *nativeDialer
+	if engine == config.EngineNative {
+		dialer = newNativeDialer(false)
+		defer dialer.Close()
+	}
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+	for i, target := range targets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			case <-ctx.Done():
+				send(broadcastDoneMsg{idx: i, exitCode: -1, err: ctx.Err(), stream: stream})
+				return
+			}
+
+			hostCtx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				hostCtx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			started := time.Now()
+			exitCode, err := runBroadcastCommand(hostCtx, dialer, target, command, func(line string) {
+				send(broadcastOutputMsg{idx: i, line: line, stream: stream})
+			})
+			if errors.Is(hostCtx.Err(), context.DeadlineExceeded) {
+				err = fmt.Errorf("timed out after %s", timeout)
+			}
+			send(broadcastDoneMsg{idx: i, exitCode: exitCode, err: err, duration: time.Since(started), stream: stream})
+		}()
+	}
+	wg.Wait()
+	send(broadcastFinishedMsg{stream: stream})
+}
+
+// runBroadcastCommand runs command on one target without a terminal, with
+// dialer unless it is nil, and passes every line of its combined output to
+// output. The target is reached over ssh, never an SSM shell. It returns the
+// remote exit status, or -1 if the command could not be run.
+func runBroadcastCommand(ctx context.Context, dialer *nativeDialer, target remoteTarget, command string, output func(string)) (int, error) {
+	conn := target.conn
+	if conn.Auth == config.AuthInstanceConnect {
+		key, err := useInstanceConnect(target.inst, &conn)
+		switch {
+		case err == nil:
+			defer key.Remove()
+		case conn.KeyPath == "":
+			return -1, err
+		}
+	}
+	// Never prompt: there is no terminal to answer on
+	conn.Options = append(conn.Options, "BatchMode=yes")
+
+	if dialer != nil {
+		target.conn = conn
+		return dialer.exec(ctx, target, command, output)
+	}
+
+	name, args, err := connectArgs(target.inst, conn, target.transport, command)
+	if err != nil {
+		return -1, err
+	}
+
+	pr, pw := io.Pipe()
+	cmd := exec.CommandContext(ctx, name, args...)
+	cmd.Stdout = pw
+	cmd.Stderr = pw
+	// Do not wait forever for output from processes ssh leaves behind,
+	// e.g. an SSM ProxyCommand
+	cmd.WaitDelay = 5 * time.Second
+	if err := cmd.Start(); err != nil {
+		return -1, err
+	}
+
+	scanned := scanLines(pr, output)
+	err = cmd.Wait()
+	pw.Close()
+	<-scanned
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() >= 0:
+		return exitErr.ExitCode(), nil
+	default:
+		return -1, err
+	}
+}
+
+// addOutput appends a line to a result, dropping the oldest lines past
+// broadcastOutputLimit
+func (r *broadcastResult) addOutput(line string) {
+	r.output = append(r.output, line)
+	if len(r.output) > broadcastOutputLimit {
+		r.output = r.output[len(r.output)-broadcastOutputLimit:]
+	}
+}
+
+// broadcastExport is the JSON form of a broadcast run
+type broadcastExport struct {
+	Command string                  `json:"command"`
+	Started time.Time               `json:"started"`
+	Results []broadcastExportResult `json:"results"`
+}
+
+type broadcastExportResult struct {
+	InstanceID  string   `json:"instance_id"`
+	Name        string   `json:"name"`
+	Profile     string   `json:"profile"`
+	Region      string   `json:"region"`
+	Environment string   `json:"environment"`
+	Transport   string   `json:"transport"`
+	Done        bool     `json:"done"`
+	ExitCode    int      `json:"exit_code"`
+	Error       string   `json:"error,omitempty"`
+	Duration    float64  `json:"duration_seconds"`
+	Output      []string `json:"output"`
+}
+
+// export writes the run as JSON to a new file in the working directory and
+// returns its name
+func (b broadcastRun) export() (string, error) {
+	out := broadcastExport{Command: b.command, Started: b.started}
+	for _, r := range b.results {
+		result := broadcastExportResult{
+			InstanceID:  r.inst.ID,
+			Name:        r.inst.Name,
+			Profile:     r.inst.Profile,
+			Region:      r.inst.Region,
+			Environment: r.inst.Environment,
+			Transport:   r.transport,
+			Done:        r.done,
+			ExitCode:    r.exitCode,
+			Duration:    r.duration.Seconds(),
+			Output:      r.output,
+		}
+		if r.err != nil {
+			result.Error = r.err.Error()
+		}
+		out.Results = append(out.Results, result)
+	}
+
+	data, err := json.MarshalIndent(out, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	name := fmt.Sprintf("relocate-broadcast-%s.json", b.started.Format("20060102-150405"))
+	if err := os.WriteFile(name, append(data, '\n'), 0o600); err != nil {
+		return "", err
+	}
+	return name, nil
+}
@@ -0,0 +1,25 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+func TestWaitForBroadcastCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	// Nothing reads the stream, so a cancelled run drops its messages
+	stream := make(chan tea.Msg)
+	runBroadcast(ctx, nil, "uptime", "", 1, 0, stream)
+
+	msg, ok := waitForBroadcast(stream)().(broadcastFinishedMsg)
+	if !ok {
+		t.Fatalf("waitForBroadcast() = %T, want broadcastFinishedMsg", msg)
+	}
+	if msg.stream != (<-chan tea.Msg)(stream) {
+		t.Error("broadcastFinishedMsg.stream is not the cancelled run's stream")
+	}
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// inventoryCache stores the last discovered inventory of every profile and
+// region (as requested: a region name or "all") for one tag filter
+type inventoryCache struct {
+	dir    string
+	filter string
+}
+
+// cacheEntry is one cached profile and region
+type cacheEntry struct {
+	Profile   string        `json:"profile"`
+	Region    string        `json:"region"`
+	Filter    string        `json:"filter"`
+	SavedAt   time.Time     `json:"saved_at"`
+	Instances []EC2Instance `json:"instances"`
+}
+
+// newInventoryCache returns the cache for a tag filter in the configured
+// cache directory
+func newInventoryCache(filter string) (*inventoryCache, error) {
+	dir, err := appConfig.CacheDir()
+	if err != nil {
+		return nil, err
+	}
+	return &inventoryCache{dir: dir, filter: filter}, nil
+}
+
+// path returns the file of a profile and region
+func (c *inventoryCache) path(profile, region string) string {
+	sum := sha256.Sum256([]byte(profile + "\x00" + region + "\x00" + c.filter))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:8])+".json")
+}
+
+// load reads the cached inventory of a profile and region. Entries older
+// than maxAge are ignored unless maxAge is 0.
+func (c *inventoryCache) load(profile, region string, maxAge time.Duration) (cacheEntry, bool) {
+	data, err := os.ReadFile(c.path(profile, region))
+	if err != nil {
+		return cacheEntry{}, false
+	}
+
+	var entry cacheEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return cacheEntry{}, false
+	}
+	if maxAge > 0 && time.Since(entry.SavedAt) > maxAge {
+		return cacheEntry{}, false
+	}
+	return entry, true
+}
+
+// save replaces the cached inventory of a profile and region
+func (c *inventoryCache) save(profile, region string, instances []EC2Instance) error {
+	if err := os.MkdirAll(c.dir, 0o700); err != nil {
+		return err
+	}
+
+	entry := cacheEntry{
+		Profile:   profile,
+		Region:    region,
+		Filter:    c.filter,
+		SavedAt:   time.Now(),
+		Instances: make([]EC2Instance, len(instances)),
+	}
+	for i, inst := range instances {
+		inst.Change = ""
+		entry.Instances[i] = inst
+	}
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file first so a concurrent reader never sees a
+	// partial file
+	tmp, err := os.CreateTemp(c.dir, ".inventory-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), c.path(profile, region))
+}
+
+// loadCached reads the cached inventory of every source. It returns the
+// instances, reclassified with the current config, the time of the oldest
+// entry used, and the profile/region pairs that have no usable entry.
+func loadCached(cache *inventoryCache, sources []discoverySource, maxAge time.Duration) (instances []EC2Instance, asOf time.Time, missing []string) {
+	for _, source := range sources {
+		for _, region := range source.regions {
+			entry, ok := cache.load(source.profile, region, maxAge)
+			if !ok {
+				missing = append(missing, source.profile+"/"+region)
+				continue
+			}
+			if asOf.IsZero() || entry.SavedAt.Before(asOf) {
+				asOf = entry.SavedAt
+			}
+			for _, inst := range entry.Instances {
+				classifyInstance(&inst)
+				instances = append(instances, inst)
+			}
+		}
+	}
+	sortInstances(instances)
+	return instances, asOf, missing
+}
+
+// clearCache removes every cached inventory and returns how many were removed
+func clearCache() (int, error) {
+	dir, err := appConfig.CacheDir()
+	if err != nil {
+		return 0, err
+	}
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return 0, err
+	}
+	for _, file := range files {
+		if err := os.Remove(file); err != nil {
+			return 0, fmt.Errorf("failed to clear %s: %w", dir, err)
+		}
+	}
+	return len(files), nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// sshArgs builds the ssh arguments for connecting to host with the resolved
+// connection settings. Extra arguments (e.g. a remote command) are appended
+// after the destination.
+func sshArgs(conn config.Connection, host string, extra ...string) []string {
+	args := []string{"-i", conn.KeyPath}
+	if conn.Port != 0 && conn.Port != config.DefaultSSHPort {
+		args = append(args, "-p", strconv.Itoa(conn.Port))
+	}
+	if conn.ProxyJump != "" {
+		args = append(args, "-o", "ProxyCommand="+jumpProxyCommand(conn))
+	}
+	if usesEphemeralKey(conn) {
+		// Offer the instance nothing but its ephemeral key; options given
+		// here do not apply to the bastion's ssh
+		args = append(args, "-o", "IdentitiesOnly=yes")
+	}
+	for _, opt := range conn.Options {
+		args = append(args, "-o", opt)
+	}
+	args = append(args, conn.User+"@"+host)
+	return append(args, extra...)
+}
+
+// jumpProxyCommand returns the ProxyCommand that reaches the instance
+// through conn's bastion. Unlike -J it authenticates to the bastion with the
+// configured key: -i is only offered to the instance, whose key may be an
+// ephemeral one.
+func jumpProxyCommand(conn config.Connection) string {
+	user, host, port := "", conn.ProxyJump, strconv.Itoa(config.DefaultSSHPort)
+	if u, h, ok := strings.Cut(host, "@"); ok {
+		user, host = u+"@", h
+	}
+	if h, p, err := net.SplitHostPort(host); err == nil {
+		host, port = h, p
+	}
+
+	args := []string{"ssh"}
+	if conn.JumpKeyPath != "" {
+		args = append(args, "-i", shellQuote(conn.JumpKeyPath))
+	}
+	return strings.Join(append(args, "-p", port, "-W", "[%h]:%p", shellQuote(user+host)), " ")
+}
+
+// Address returns the instance's address of the given kind, or "" if it
+// has none
+func (inst EC2Instance) Address(kind string) string {
+	switch kind {
+	case config.AddressPublic:
+		return inst.PublicIP
+	case config.AddressPrivate:
+		return inst.PrivateIP
+	case config.AddressIPv6:
+		return inst.IPv6
+	case config.AddressPublicDNS:
+		return inst.PublicDNS
+	case config.AddressPrivateDNS:
+		return inst.PrivateDNS
+	}
+	return ""
+}
+
+// preferredAddress returns the first address kind in prefs that inst has.
+// ok is false if it has none of them.
+func preferredAddress(inst EC2Instance, prefs []string) (kind string, ok bool) {
+	for _, kind := range prefs {
+		if inst.Address(kind) != "" {
+			return kind, true
+		}
+	}
+	return "", false
+}
+
+// nextAddress returns the address kind after kind that inst has, cycling
+// through config.AddressKinds
+func nextAddress(inst EC2Instance, kind string) string {
+	i := slices.Index(config.AddressKinds, kind)
+	for range config.AddressKinds {
+		i = (i + 1) % len(config.AddressKinds)
+		if next := config.AddressKinds[i]; inst.Address(next) != "" {
+			return next
+		}
+	}
+	return kind
+}
+
+// awsCLIArgs returns the --profile/--region arguments that make the aws CLI
+// talk to the account and region an instance was discovered in
+func awsCLIArgs(inst EC2Instance) []string {
+	var args []string
+	if inst.Profile != "" {
+		args = append(args, "--profile", inst.Profile)
+	}
+	if inst.Region != "" {
+		args = append(args, "--region", inst.Region)
+	}
+	return args
+}
+
+// ssmProxyCommand is the ssh ProxyCommand that tunnels ssh through SSM
+func ssmProxyCommand(inst EC2Instance) string {
+	args := append([]string{"aws", "ssm", "start-session",
+		"--target", "%h",
+		"--document-name", "AWS-StartSSHSession",
+		"--parameters", "portNumber=%p",
+	}, awsCLIArgs(inst)...)
+	return strings.Join(args, " ")
+}
+
+// connectArgs returns the program and arguments that open a session on inst
+// over the given transport
+func connectArgs(inst EC2Instance, conn config.Connection, transport string, extra ...string) (string, []string, error) {
+	switch transport {
+	case config.TransportSSM:
+		if len(extra) > 0 {
+			return "", nil, fmt.Errorf("the %s transport cannot run a remote command", transport)
+		}
+		args := append([]string{"ssm", "start-session", "--target", inst.ID}, awsCLIArgs(inst)...)
+		return "aws", args, nil
+
+	case config.TransportSSHSSM:
+		if conn.KeyPath == "" {
+			return "", nil, fmt.Errorf("%w: %s (add it to ~/.relocate/config.json)", config.ErrSSHKeyNotConfigured, inst.Environment)
+		}
+		conn.Options = append(conn.Options, "ProxyCommand="+ssmProxyCommand(inst))
+		return "ssh", sshArgs(conn, inst.ID, extra...), nil
+
+	case config.TransportSSH, config.TransportNative, "":
+		if conn.KeyPath == "" {
+			return "", nil, fmt.Errorf("%w: %s (add it to ~/.relocate/config.json)", config.ErrSSHKeyNotConfigured, inst.Environment)
+		}
+		kind := conn.Address
+		if kind == "" {
+			kind, _ = preferredAddress(inst, conn.Addresses)
+		}
+		host := inst.Address(kind)
+		if host == "" {
+			return "", nil, fmt.Errorf("%s has no %s address (try the %s transport)", inst.ID, strings.Join(conn.Addresses, ", "), config.TransportSSHSSM)
+		}
+		return "ssh", sshArgs(conn, host, extra...), nil
+	}
+
+	return "", nil, fmt.Errorf("unknown transport %q", transport)
+}
+
+// connectCommand returns an interactive session command attached to the
+// terminal. Extra arguments are run as a remote command instead of a shell.
+func connectCommand(inst EC2Instance, conn config.Connection, transport string, extra ...string) (*exec.Cmd, error) {
+	name, args, err := connectArgs(inst, conn, transport, extra...)
+	if err != nil {
+		return nil, err
+	}
+
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd, nil
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+func TestJumpProxyCommand(t *testing.T) {
+	tests := []struct {
+		name string
+		conn config.Connection
+		want string
+	}{
+		{
+			name: "configured key",
+			conn: config.Connection{ProxyJump: "ec2-user@bastion.example.com", KeyPath: "/keys/app.pem", JumpKeyPath: "/keys/app.pem"},
+			want: "ssh -i /keys/app.pem -p 22 -W [%h]:%p ec2-user@bastion.example.com",
+		},
+		{
+			name: "ephemeral instance key",
+			conn: config.Connection{ProxyJump: "ec2-user@10.0.0.5:2222", KeyPath: "/tmp/relocate-1/id_ed25519", JumpKeyPath: "/keys/my key.pem"},
+			want: "ssh -i '/keys/my key.pem' -p 2222 -W [%h]:%p ec2-user@10.0.0.5",
+		},
+		{
+			name: "no key uses the agent",
+			conn: config.Connection{ProxyJump: "admin@[2001:db8::1]:2200"},
+			want: "ssh -p 2200 -W [%h]:%p admin@2001:db8::1",
+		},
+		{
+			name: "no user",
+			conn: config.Connection{ProxyJump: "bastion"},
+			want: "ssh -p 22 -W [%h]:%p bastion",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := jumpProxyCommand(tt.conn); got != tt.want {
+				t.Errorf("jumpProxyCommand() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
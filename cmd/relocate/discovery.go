@@ -0,0 +1,522 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// discoveryPageSize is the number of instances requested per DescribeInstances call
+const discoveryPageSize = 1000
+
+// Discovery messages. Pages are streamed into the TUI as they arrive; each
+// page message carries the stream so Update can wait for the next one.
+type instancesPageMsg struct {
+	instances []EC2Instance
+	stream    <-chan tea.Msg
+}
+
+// ssmStatusMsg reports the SSM agent status of the instances of one target.
+// Instances in checked that are missing from status are not managed by SSM.
+type ssmStatusMsg struct {
+	status  map[string]string
+	checked []string
+	stream  <-chan tea.Msg
+}
+
+type instancesLoadedMsg struct {
+	warnings []string
+}
+
+// waitForDiscovery returns a command that reads the next message from a
+// discovery stream
+func waitForDiscovery(stream <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		msg, ok := <-stream
+		if !ok {
+			return instancesLoadedMsg{}
+		}
+		return msg
+	}
+}
+
+// allRegions is the --region value that expands to every enabled region
+const allRegions = "all"
+
+// defaultParallelism bounds concurrent AWS queries when discovery.parallelism is not set
+const defaultParallelism = 4
+
+// splitList splits a comma separated flag value, dropping blanks and duplicates
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		item = strings.TrimSpace(item)
+		if item != "" && !slices.Contains(items, item) {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// Fallbacks when neither a flag, an environment nor the config defaults name a profile or region
+const (
+	fallbackProfile = "default"
+	fallbackRegion  = "ap-southeast-1"
+)
+
+// discoverySource is a profile and the regions to query with it
+type discoverySource struct {
+	profile string
+	regions []string
+}
+
+// discoverySources works out which profiles and regions to query: the
+// profile and region of the defaults block and of every environment,
+// resolved like the other connection settings (CLI flag > environment >
+// defaults). A --profile or --region flag therefore replaces all of them.
+func discoverySources(overrides config.Overrides) ([]discoverySource, error) {
+	type group struct{ profile, region string }
+	groups := []group{{
+		profile: cmp.Or(overrides.AWSProfile, appConfig.Defaults.AWSProfile),
+		region:  cmp.Or(overrides.AWSRegion, appConfig.Defaults.AWSRegion),
+	}}
+	for _, env := range appConfig.EnvironmentList() {
+		conn := appConfig.ResolveConnection(env.Name, overrides)
+		groups = append(groups, group{profile: conn.AWSProfile, region: conn.AWSRegion})
+	}
+
+	var sources []discoverySource
+	for _, g := range groups {
+		profiles, err := expandProfiles(splitList(cmp.Or(g.profile, fallbackProfile)))
+		if err != nil {
+			return nil, err
+		}
+		regions := splitList(cmp.Or(g.region, fallbackRegion))
+
+		for _, profile := range profiles {
+			i := slices.IndexFunc(sources, func(s discoverySource) bool { return s.profile == profile })
+			if i < 0 {
+				sources = append(sources, discoverySource{profile: profile})
+				i = len(sources) - 1
+			}
+			for _, region := range regions {
+				if !slices.Contains(sources[i].regions, region) {
+					sources[i].regions = append(sources[i].regions, region)
+				}
+			}
+		}
+	}
+	return sources, nil
+}
+
+// discoveryTarget is one account and region pair to query
+type discoveryTarget struct {
+	profile   string
+	accountID string
+	region    string
+	regionKey string // the requested region: region itself, or allRegions
+	client    ec2.DescribeInstancesAPIClient
+	ssm       ssm.DescribeInstanceInformationAPIClient
+}
+
+// discoveryFailure is a profile or target that could not be queried
+type discoveryFailure struct {
+	source string
+	err    error
+}
+
+// loadInstances starts discovery, which stops when ctx is cancelled. When
+// cache is not nil, the inventory of every profile and requested region that
+// loads completely is saved to it.
+func loadInstances(ctx context.Context, sources []discoverySource, filterTag string, cache *inventoryCache) tea.Cmd {
+	return func() tea.Msg {
+		if len(sources) == 0 {
+			return errorMsg{err: "No AWS profile configured"}
+		}
+
+		timeout, err := appConfig.DiscoveryTimeout()
+		if err != nil {
+			return errorMsg{err: err.Error()}
+		}
+
+		parallelism := appConfig.Discovery.Parallelism
+		if parallelism == 0 {
+			parallelism = defaultParallelism
+		}
+
+		stream := make(chan tea.Msg)
+		go streamInventory(ctx, sources, describeInput(filterTag), timeout, appConfig.Discovery.MaxInstances, parallelism, cache, stream)
+
+		return waitForDiscovery(stream)()
+	}
+}
+
+// resolveTargets loads the AWS config for a profile, looks up its account and
+// expands the requested regions into one target per region
+func resolveTargets(ctx context.Context, profile string, regions []string) ([]discoveryTarget, error) {
+	baseRegion := regions[0]
+	if baseRegion == allRegions {
+		baseRegion = ""
+	}
+	cfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithSharedConfigProfile(profile),
+		awsconfig.WithRegion(baseRegion),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	if cfg.Region == "" {
+		cfg.Region = "us-east-1"
+	}
+
+	identity, err := sts.NewFromConfig(cfg).GetCallerIdentity(ctx, &sts.GetCallerIdentityInput{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get caller identity: %w", err)
+	}
+
+	regionKey := ""
+	if slices.Contains(regions, allRegions) {
+		regions, err = enabledRegions(ctx, ec2.NewFromConfig(cfg))
+		if err != nil {
+			return nil, fmt.Errorf("failed to list regions: %w", err)
+		}
+		regionKey = allRegions
+	}
+
+	var targets []discoveryTarget
+	for _, region := range regions {
+		targets = append(targets, discoveryTarget{
+			profile:   profile,
+			accountID: aws.ToString(identity.Account),
+			region:    region,
+			regionKey: cmp.Or(regionKey, region),
+			client: ec2.NewFromConfig(cfg, func(o *ec2.Options) {
+				o.Region = region
+			}),
+			ssm: ssm.NewFromConfig(cfg, func(o *ssm.Options) {
+				o.Region = region
+			}),
+		})
+	}
+	return targets, nil
+}
+
+// enabledRegions lists the regions enabled for the account, sorted by name
+func enabledRegions(ctx context.Context, client *ec2.Client) ([]string, error) {
+	resp, err := client.DescribeRegions(ctx, &ec2.DescribeRegionsInput{})
+	if err != nil {
+		return nil, err
+	}
+
+	var regions []string
+	for _, region := range resp.Regions {
+		regions = append(regions, aws.ToString(region.RegionName))
+	}
+	slices.Sort(regions)
+	return regions, nil
+}
+
+// ssmPingStatus returns the SSM agent ping status of every managed instance,
+// keyed by instance ID
+func ssmPingStatus(ctx context.Context, client ssm.DescribeInstanceInformationAPIClient) (map[string]string, error) {
+	status := make(map[string]string)
+	paginator := ssm.NewDescribeInstanceInformationPaginator(client, &ssm.DescribeInstanceInformationInput{})
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, info := range resp.InstanceInformationList {
+			status[aws.ToString(info.InstanceId)] = string(info.PingStatus)
+		}
+	}
+	return status, nil
+}
+
+// describeInput builds the DescribeInstances request for an optional Key=Value tag filter
+func describeInput(filterTag string) *ec2.DescribeInstancesInput {
+	filters := []types.Filter{
+		{
+			Name:   aws.String("instance-state-name"),
+			Values: []string{"pending", "running", "stopping", "stopped"},
+		},
+	}
+
+	if filterTag != "" {
+		parts := strings.SplitN(filterTag, "=", 2)
+		if len(parts) == 2 {
+			filters = append(filters, types.Filter{
+				Name:   aws.String("tag:" + parts[0]),
+				Values: []string{parts[1]},
+			})
+		}
+	}
+
+	return &ec2.DescribeInstancesInput{
+		Filters:    filters,
+		MaxResults: aws.Int32(discoveryPageSize),
+	}
+}
+
+// streamInventory runs discovery for every profile and region, at most
+// parallelism AWS calls at a time, and sends a message per page to stream
+// followed by a final instancesLoadedMsg or errorMsg. A profile or region
+// that fails becomes a warning unless nothing could be loaded at all. The
+// stream is closed when discovery ends. Cancelling ctx stops discovery and
+// any send the stream is no longer read for.
+func streamInventory(ctx context.Context, sources []discoverySource, input *ec2.DescribeInstancesInput, timeout time.Duration, maxInstances, parallelism int, cache *inventoryCache, stream chan tea.Msg) {
+	defer close(stream)
+
+	send := func(msg tea.Msg) {
+		select {
+		case stream <- msg:
+		case <-ctx.Done():
+		}
+	}
+
+	queryCtx := ctx
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		queryCtx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	var (
+		mu       sync.Mutex
+		wg       sync.WaitGroup
+		warnings []string
+		failures []discoveryFailure
+		targets  int
+		loaded   int
+		results  = make(map[[2]string][]EC2Instance) // by profile and requested region
+		failed   = make(map[[2]string]bool)
+	)
+	sem := make(chan struct{}, max(1, parallelism))
+
+	fail := func(source string, err error) {
+		mu.Lock()
+		defer mu.Unlock()
+		if errors.Is(queryCtx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", timeout)
+		}
+		failures = append(failures, discoveryFailure{source: source, err: err})
+	}
+
+	discover := func(target discoveryTarget) {
+		defer wg.Done()
+		sem <- struct{}{}
+		defer func() { <-sem }()
+
+		source := target.region
+		if len(sources) > 1 {
+			source = target.profile + "/" + target.region
+		}
+
+		var ids []string
+		var found []EC2Instance
+		truncated, err := discoverInstances(queryCtx, target.client, input, maxInstances, func(page []EC2Instance) {
+			for i := range page {
+				page[i].Profile = target.profile
+				page[i].AccountID = target.accountID
+				page[i].Region = target.region
+				classifyInstance(&page[i])
+			}
+			for _, inst := range page {
+				ids = append(ids, inst.ID)
+			}
+			found = append(found, page...)
+			send(instancesPageMsg{instances: slices.Clone(page), stream: stream})
+		})
+		targetLoaded := len(ids)
+
+		// SSM status is best effort: without ssm:DescribeInstanceInformation
+		// permission it is simply shown as unknown
+		if len(ids) > 0 && target.ssm != nil {
+			if status, err := ssmPingStatus(queryCtx, target.ssm); err == nil {
+				msg := ssmStatusMsg{status: status, checked: ids, stream: stream}
+				applySSMStatus(found, msg)
+				send(msg)
+			}
+		}
+
+		mu.Lock()
+		key := [2]string{target.profile, target.regionKey}
+		results[key] = append(results[key], found...)
+		if err != nil {
+			failed[key] = true
+		}
+		loaded += targetLoaded
+		if truncated {
+			warnings = append(warnings, fmt.Sprintf("%s: showing the first %d instances (discovery.max_instances)", source, targetLoaded))
+		}
+		mu.Unlock()
+
+		if err != nil {
+			fail(source, err)
+		}
+	}
+
+	for _, source := range sources {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			resolved, err := resolveTargets(queryCtx, source.profile, source.regions)
+			<-sem
+			if err != nil {
+				fail(source.profile, err)
+				return
+			}
+
+			mu.Lock()
+			targets += len(resolved)
+			mu.Unlock()
+			for _, target := range resolved {
+				wg.Add(1)
+				go discover(target)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if cache != nil {
+		for key, instances := range results {
+			if !failed[key] {
+				if err := cache.save(key[0], key[1], instances); err != nil {
+					warnings = append(warnings, fmt.Sprintf("cache: %v", err))
+				}
+			}
+		}
+	}
+
+	for _, failure := range failures {
+		warnings = append(warnings, fmt.Sprintf("%s: %v", failure.source, failure.err))
+	}
+	slices.Sort(warnings)
+	if loaded == 0 && len(failures) > 0 && len(failures) >= targets {
+		send(errorMsg{err: strings.Join(warnings, "; ")})
+		return
+	}
+	send(instancesLoadedMsg{warnings: warnings})
+}
+
+// classifyInstance sets the account alias and environment of an instance
+// whose profile, account and region are known
+func classifyInstance(inst *EC2Instance) {
+	inst.Account = appConfig.AccountAlias(inst.AccountID)
+	inst.Environment = appConfig.Classify(config.InstanceAttributes{
+		Name:      inst.Name,
+		KeyName:   inst.KeyName,
+		VpcID:     inst.VpcID,
+		AccountID: inst.AccountID,
+		Profile:   inst.Profile,
+		Region:    inst.Region,
+		Tags:      inst.Tags,
+	})
+}
+
+// discoverInstances pages through DescribeInstances and calls onPage with the
+// instances of each page. Discovery stops once maxInstances have been seen
+// (0 means no cap), in which case truncated is true.
+func discoverInstances(ctx context.Context, client ec2.DescribeInstancesAPIClient, input *ec2.DescribeInstancesInput, maxInstances int, onPage func([]EC2Instance)) (truncated bool, err error) {
+	paginator := ec2.NewDescribeInstancesPaginator(client, input)
+
+	seen := 0
+	for paginator.HasMorePages() {
+		resp, err := paginator.NextPage(ctx)
+		if err != nil {
+			return false, err
+		}
+
+		var page []EC2Instance
+		for _, res := range resp.Reservations {
+			for _, inst := range res.Instances {
+				if maxInstances > 0 && seen >= maxInstances {
+					truncated = true
+					break
+				}
+				page = append(page, newEC2Instance(inst))
+				seen++
+			}
+		}
+
+		if len(page) > 0 {
+			onPage(page)
+		}
+		if truncated || (maxInstances > 0 && seen >= maxInstances && paginator.HasMorePages()) {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+// newEC2Instance converts an SDK instance into the fields shown in the TUI
+func newEC2Instance(inst types.Instance) EC2Instance {
+	tags := make(map[string]string, len(inst.Tags))
+	for _, tag := range inst.Tags {
+		tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+
+	publicIP := aws.ToString(inst.PublicIpAddress)
+	privateIP := aws.ToString(inst.PrivateIpAddress)
+	ipv6 := aws.ToString(inst.Ipv6Address)
+
+	zone := ""
+	if inst.Placement != nil {
+		zone = aws.ToString(inst.Placement.AvailabilityZone)
+	}
+
+	state := ""
+	if inst.State != nil {
+		state = string(inst.State.Name)
+	}
+
+	return EC2Instance{
+		ID:         aws.ToString(inst.InstanceId),
+		Name:       tags["Name"],
+		IP:         cmp.Or(publicIP, privateIP, ipv6),
+		PublicIP:   publicIP,
+		PrivateIP:  privateIP,
+		IPv6:       ipv6,
+		PublicDNS:  aws.ToString(inst.PublicDnsName),
+		PrivateDNS: aws.ToString(inst.PrivateDnsName),
+		State:      state,
+		Type:       string(inst.InstanceType),
+		Zone:       zone,
+		VpcID:      aws.ToString(inst.VpcId),
+		KeyName:    aws.ToString(inst.KeyName),
+		AMI:        aws.ToString(inst.ImageId),
+		Tags:       tags,
+	}
+}
+
+// sortInstances sorts instances alphabetically by name (or ID if name is empty)
+func sortInstances(instances []EC2Instance) {
+	slices.SortFunc(instances, func(a, b EC2Instance) int {
+		aName := a.Name
+		if aName == "" {
+			aName = a.ID
+		}
+		bName := b.Name
+		if bName == "" {
+			bName = b.ID
+		}
+		return strings.Compare(aName, bName)
+	})
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"slices"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// fakeEC2 serves DescribeInstances from fixed pages, linked by NextToken
+type fakeEC2 struct {
+	pages  [][]string // instance IDs of each page
+	failAt int        // page that fails, -1 for none
+	calls  int
+}
+
+func (f *fakeEC2) DescribeInstances(_ context.Context, params *ec2.DescribeInstancesInput, _ ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error) {
+	f.calls++
+	page := 0
+	if token := aws.ToString(params.NextToken); token != "" {
+		fmt.Sscanf(token, "page-%d", &page)
+	}
+	if page == f.failAt {
+		return nil, errors.New("throttled")
+	}
+
+	var instances []types.Instance
+	for _, id := range f.pages[page] {
+		instances = append(instances, types.Instance{
+			InstanceId: aws.String(id),
+			State:      &types.InstanceState{Name: types.InstanceStateNameRunning},
+			Tags:       []types.Tag{{Key: aws.String("Name"), Value: aws.String("web-" + id)}},
+		})
+	}
+	out := &ec2.DescribeInstancesOutput{
+		// Split each page over two reservations, as EC2 groups instances by launch
+		Reservations: []types.Reservation{{Instances: instances[:len(instances)/2]}, {Instances: instances[len(instances)/2:]}},
+	}
+	if page+1 < len(f.pages) {
+		out.NextToken = aws.String(fmt.Sprintf("page-%d", page+1))
+	}
+	return out, nil
+}
+
+func TestDiscoverInstances(t *testing.T) {
+	pages := [][]string{{"i-1", "i-2"}, {"i-3"}, {"i-4", "i-5"}}
+
+	tests := []struct {
+		name          string
+		maxInstances  int
+		failAt        int
+		wantPages     [][]string
+		wantTruncated bool
+		wantCalls     int
+		wantErr       bool
+	}{
+		{
+			name:      "every page",
+			failAt:    -1,
+			wantPages: [][]string{{"i-1", "i-2"}, {"i-3"}, {"i-4", "i-5"}},
+			wantCalls: 3,
+		},
+		{
+			name:          "truncated mid page",
+			maxInstances:  4,
+			failAt:        -1,
+			wantPages:     [][]string{{"i-1", "i-2"}, {"i-3"}, {"i-4"}},
+			wantTruncated: true,
+			wantCalls:     3,
+		},
+		{
+			name:          "truncated at a page boundary",
+			maxInstances:  3,
+			failAt:        -1,
+			wantPages:     [][]string{{"i-1", "i-2"}, {"i-3"}},
+			wantTruncated: true,
+			wantCalls:     2,
+		},
+		{
+			name:         "cap equal to the inventory",
+			maxInstances: 5,
+			failAt:       -1,
+			wantPages:    [][]string{{"i-1", "i-2"}, {"i-3"}, {"i-4", "i-5"}},
+			wantCalls:    3,
+		},
+		{
+			name:      "error mid pagination",
+			failAt:    1,
+			wantPages: [][]string{{"i-1", "i-2"}},
+			wantCalls: 2,
+			wantErr:   true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &fakeEC2{pages: pages, failAt: tt.failAt}
+			var got [][]string
+			truncated, err := discoverInstances(context.Background(), client, &ec2.DescribeInstancesInput{}, tt.maxInstances, func(page []EC2Instance) {
+				var ids []string
+				for _, inst := range page {
+					if inst.Name != "web-"+inst.ID || inst.State != "running" {
+						t.Errorf("instance %s converted as %+v", inst.ID, inst)
+					}
+					ids = append(ids, inst.ID)
+				}
+				got = append(got, ids)
+			})
+
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("err = %v, want error %v", err, tt.wantErr)
+			}
+			if truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", truncated, tt.wantTruncated)
+			}
+			if !slices.EqualFunc(got, tt.wantPages, slices.Equal) {
+				t.Errorf("pages = %v, want %v", got, tt.wantPages)
+			}
+			if client.calls != tt.wantCalls {
+				t.Errorf("DescribeInstances called %d times, want %d", client.calls, tt.wantCalls)
+			}
+		})
+	}
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// favourite is a starred instance, matched by ID. Name, profile and region
+// find the instance that replaced it once the ID is gone.
+type favourite struct {
+	InstanceID string `json:"instance_id"`
+	Name       string `json:"name"`
+	Profile    string `json:"profile"`
+	Region     string `json:"region,omitempty"`
+}
+
+// favourites are the starred instances, stored in ~/.relocate/favourites.json
+type favourites []favourite
+
+// favouritesPath returns the favourites file
+func favouritesPath() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "favourites.json"), nil
+}
+
+// loadFavourites reads the starred instances
+func loadFavourites() (favourites, error) {
+	path, err := favouritesPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var favs favourites
+	if err := json.Unmarshal(data, &favs); err != nil {
+		return nil, err
+	}
+	return favs, nil
+}
+
+// save replaces the stored favourites
+func (f favourites) save() error {
+	path, err := favouritesPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(f, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".favourites-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// replacedBy reports whether inst may have replaced the starred instance:
+// it has the same Name tag in the same profile and region
+func (fav favourite) replacedBy(inst EC2Instance) bool {
+	return fav.Name != "" && fav.Name == inst.Name && fav.Profile == inst.Profile &&
+		(fav.Region == "" || fav.Region == inst.Region)
+}
+
+// contains reports whether inst is starred
+func (f favourites) contains(inst EC2Instance) bool {
+	return slices.ContainsFunc(f, func(fav favourite) bool { return fav.InstanceID == inst.ID })
+}
+
+// toggle stars inst, or unstars it if it is starred, and reports whether it
+// is starred now
+func (f favourites) toggle(inst EC2Instance) (favourites, bool) {
+	if f.contains(inst) {
+		return slices.DeleteFunc(slices.Clone(f), func(fav favourite) bool { return fav.InstanceID == inst.ID }), false
+	}
+	return append(f, favourite{InstanceID: inst.ID, Name: inst.Name, Profile: inst.Profile, Region: inst.Region}), true
+}
+
+// repin points every favourite whose instance is missing from a complete
+// inventory at the unstarred instance that replaced it, if there is one, so
+// that a star survives instance replacement. It reports whether any
+// favourite changed.
+func (f favourites) repin(instances []EC2Instance) (favourites, bool) {
+	present := make(map[string]bool, len(instances))
+	for _, inst := range instances {
+		if inst.Change != changeRemoved {
+			present[inst.ID] = true
+		}
+	}
+
+	repinned := slices.Clone(f)
+	changed := false
+	for i, fav := range repinned {
+		if present[fav.InstanceID] {
+			continue
+		}
+		for _, inst := range instances {
+			if inst.Change != changeRemoved && fav.replacedBy(inst) && !repinned.contains(inst) {
+				repinned[i].InstanceID = inst.ID
+				repinned[i].Region = inst.Region
+				changed = true
+				break
+			}
+		}
+	}
+	return repinned, changed
+}
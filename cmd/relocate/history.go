@@ -0,0 +1,211 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// historyLimit is the number of most recent connections read from history
+const historyLimit = 1000
+
+// historyEntry is one recorded connection, a line of ~/.relocate/history.jsonl
+type historyEntry struct {
+	Time        time.Time `json:"time"`
+	Profile     string    `json:"profile"`
+	Region      string    `json:"region"`
+	Zone        string    `json:"zone,omitempty"`
+	InstanceID  string    `json:"instance_id"`
+	Name        string    `json:"name"`
+	Environment string    `json:"environment"`
+	Address     string    `json:"address"`
+	AddressKind string    `json:"address_kind,omitempty"`
+	User        string    `json:"user"`
+	Transport   string    `json:"transport"`
+	ExitCode    int       `json:"exit_code"`
+	Duration    float64   `json:"duration_seconds"`
+}
+
+// historyPath returns the history file
+func historyPath() (string, error) {
+	dir, err := config.Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "history.jsonl"), nil
+}
+
+// newHistoryEntry records a finished session
+func newHistoryEntry(result sessionResult) historyEntry {
+	return historyEntry{
+		Time:        result.started,
+		Profile:     result.inst.Profile,
+		Region:      result.inst.Region,
+		Zone:        result.inst.Zone,
+		InstanceID:  result.inst.ID,
+		Name:        result.inst.Name,
+		Environment: result.inst.Environment,
+		Address:     result.target,
+		AddressKind: result.addressKind,
+		User:        result.user,
+		Transport:   result.transport,
+		ExitCode:    result.exitCode,
+		Duration:    result.duration.Seconds(),
+	}
+}
+
+// loadHistory reads the most recent connections, oldest first. Lines that
+// cannot be parsed are skipped.
+func loadHistory() ([]historyEntry, error) {
+	path, err := historyPath()
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var entries []historyEntry
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var entry historyEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			continue
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) > historyLimit {
+		entries = entries[len(entries)-historyLimit:]
+	}
+	return entries, scanner.Err()
+}
+
+// recordSession appends a session to the history. Sessions that never
+// started are not recorded.
+func recordSession(result sessionResult) error {
+	if result.exitCode < 0 {
+		return nil
+	}
+
+	path, err := historyPath()
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(newHistoryEntry(result))
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to record history: %w", err)
+	}
+	defer f.Close()
+	_, err = f.Write(append(data, '\n'))
+	return err
+}
+
+// frecencyScores ranks instances, by ID, on how often and how recently they
+// were connected to: every connection adds a weight that shrinks with age
+func frecencyScores(entries []historyEntry, now time.Time) map[string]float64 {
+	const day = 24 * time.Hour
+
+	scores := make(map[string]float64)
+	for _, entry := range entries {
+		age := now.Sub(entry.Time)
+		switch {
+		case age < 4*day:
+			scores[entry.InstanceID] += 100
+		case age < 14*day:
+			scores[entry.InstanceID] += 70
+		case age < 31*day:
+			scores[entry.InstanceID] += 50
+		case age < 90*day:
+			scores[entry.InstanceID] += 30
+		default:
+			scores[entry.InstanceID] += 10
+		}
+	}
+	return scores
+}
+
+// instance rebuilds the instance of a history entry, for reconnecting to it
+// when it is not in the cached inventory
+func (e historyEntry) instance() EC2Instance {
+	inst := EC2Instance{
+		ID:          e.InstanceID,
+		Name:        e.Name,
+		IP:          e.Address,
+		State:       "running",
+		Profile:     e.Profile,
+		Region:      e.Region,
+		Zone:        e.Zone,
+		Environment: e.Environment,
+	}
+	switch e.AddressKind {
+	case config.AddressPublic:
+		inst.PublicIP = e.Address
+	case config.AddressPrivate:
+		inst.PrivateIP = e.Address
+	case config.AddressIPv6:
+		inst.IPv6 = e.Address
+	case config.AddressPublicDNS:
+		inst.PublicDNS = e.Address
+	case config.AddressPrivateDNS:
+		inst.PrivateDNS = e.Address
+	}
+	return inst
+}
+
+// reconnectModel prepares a model that connects to the instance of a
+// history entry, as it was connected to before. The instance and its
+// possible tag bastions are taken from the cached inventory when available.
+func reconnectModel(overrides config.Overrides, entry historyEntry) model {
+	if overrides.SSHUser == "" {
+		overrides.SSHUser = entry.User
+	}
+	m := model{
+		overrides: overrides,
+		target:    entry.instance(),
+		transport: cmpTransport(overrides.Transport, entry.Transport),
+		address:   entry.AddressKind,
+	}
+
+	if cache, err := newInventoryCache(""); err == nil {
+		sources := []discoverySource{{profile: entry.Profile, regions: []string{entry.Region, allRegions}}}
+		m.instances, _, _ = loadCached(cache, sources, 0)
+	}
+	for _, inst := range m.instances {
+		if inst.ID == entry.InstanceID && (entry.AddressKind == "" || inst.Address(entry.AddressKind) != "") {
+			m.target = inst
+			break
+		}
+	}
+	return m
+}
+
+// cmpTransport returns the transport given on the command line, or else the
+// recorded one
+func cmpTransport(flag, recorded string) string {
+	if flag != "" {
+		return flag
+	}
+	if recorded == "" {
+		return config.TransportSSH
+	}
+	return recorded
+}
@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2instanceconnect"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// instanceConnectTimeout bounds pushing the ephemeral key. The key is only
+// accepted by the instance for 60 seconds after it is pushed.
+const instanceConnectTimeout = 15 * time.Second
+
+// ephemeralKey is a private key written to a temporary directory for the
+// duration of one session
+type ephemeralKey struct {
+	dir       string
+	path      string
+	publicKey string // authorized_keys format
+}
+
+// newEphemeralKey generates an ed25519 key pair and writes the private key,
+// readable only by the current user, to a new temporary directory
+func newEphemeralKey() (*ephemeralKey, error) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return nil, fmt.Errorf("failed to generate key: %w", err)
+	}
+
+	sshPub, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %w", err)
+	}
+	block, err := ssh.MarshalPrivateKey(priv, "relocate ephemeral key")
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode private key: %w", err)
+	}
+
+	dir, err := os.MkdirTemp("", "relocate-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create key directory: %w", err)
+	}
+	key := &ephemeralKey{
+		dir:       dir,
+		path:      filepath.Join(dir, "id_ed25519"),
+		publicKey: string(ssh.MarshalAuthorizedKey(sshPub)),
+	}
+	if err := os.WriteFile(key.path, pem.EncodeToMemory(block), 0o600); err != nil {
+		key.Remove()
+		return nil, fmt.Errorf("failed to write key: %w", err)
+	}
+	return key, nil
+}
+
+// Remove deletes the private key and its directory
+func (k *ephemeralKey) Remove() {
+	os.RemoveAll(k.dir)
+}
+
+// sendSSHPublicKey pushes a public key to an instance with EC2 Instance
+// Connect, using the profile and region the instance was discovered in
+func sendSSHPublicKey(inst EC2Instance, osUser, publicKey string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), instanceConnectTimeout)
+	defer cancel()
+
+	cfg, err := instanceAWSConfig(ctx, inst)
+	if err != nil {
+		return err
+	}
+
+	resp, err := ec2instanceconnect.NewFromConfig(cfg).SendSSHPublicKey(ctx, &ec2instanceconnect.SendSSHPublicKeyInput{
+		InstanceId:       aws.String(inst.ID),
+		InstanceOSUser:   aws.String(osUser),
+		SSHPublicKey:     aws.String(publicKey),
+		AvailabilityZone: aws.String(inst.Zone),
+	})
+	if err != nil {
+		return err
+	}
+	if !resp.Success {
+		return fmt.Errorf("EC2 Instance Connect did not accept the key")
+	}
+	return nil
+}
+
+// pushEphemeralKey generates an ephemeral key and pushes it to inst for the
+// given OS user. The caller removes the key once the session ends.
+func pushEphemeralKey(inst EC2Instance, osUser string) (*ephemeralKey, error) {
+	key, err := newEphemeralKey()
+	if err != nil {
+		return nil, err
+	}
+	if err := sendSSHPublicKey(inst, osUser, key.publicKey); err != nil {
+		key.Remove()
+		return nil, fmt.Errorf("EC2 Instance Connect: %w", err)
+	}
+	return key, nil
+}
+
+// useInstanceConnect pushes an ephemeral key to inst for conn's user and
+// points conn at it. The caller removes the key once the connection ends.
+// A bastion keeps authenticating with the configured key.
+func useInstanceConnect(inst EC2Instance, conn *config.Connection) (*ephemeralKey, error) {
+	key, err := pushEphemeralKey(inst, conn.User)
+	if err != nil {
+		return nil, err
+	}
+	conn.KeyPath = key.path
+	return key, nil
+}
+
+// usesEphemeralKey reports whether conn authenticates to the instance with
+// a key pushed by useInstanceConnect, which no other host accepts
+func usesEphemeralKey(conn config.Connection) bool {
+	return conn.KeyPath != conn.JumpKeyPath
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"cmp"
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// fetchInventory loads the inventory without the browser: the cached one
+// when every source is cached and fresh, otherwise by running discovery to
+// the end. Offline only the cache is used, however old. The warnings name
+// sources that could not be loaded.
+func fetchInventory(overrides config.Overrides, filterTag string, offline bool) (instances []EC2Instance, warnings []string, err error) {
+	sources, err := discoverySources(overrides)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(sources) == 0 {
+		return nil, nil, errors.New("no AWS profile configured")
+	}
+
+	ttl, err := appConfig.CacheTTL()
+	if err != nil {
+		return nil, nil, err
+	}
+	var cache *inventoryCache
+	if ttl > 0 || offline {
+		if cache, err = newInventoryCache(filterTag); err != nil {
+			return nil, nil, err
+		}
+	}
+	if cache != nil {
+		maxAge := ttl
+		if offline {
+			maxAge = 0
+		}
+		instances, _, missing := loadCached(cache, sources, maxAge)
+		if offline {
+			if len(instances) == 0 && len(missing) > 0 {
+				return nil, nil, fmt.Errorf("no cached inventory for %s (run relocate without --offline first)", strings.Join(missing, ", "))
+			}
+			for _, source := range missing {
+				warnings = append(warnings, source+": not cached")
+			}
+			return instances, warnings, nil
+		}
+		if len(missing) == 0 {
+			return instances, nil, nil
+		}
+	}
+
+	timeout, err := appConfig.DiscoveryTimeout()
+	if err != nil {
+		return nil, nil, err
+	}
+	parallelism := cmp.Or(appConfig.Discovery.Parallelism, defaultParallelism)
+	stream := make(chan tea.Msg)
+	go streamInventory(context.Background(), sources, describeInput(filterTag), timeout, appConfig.Discovery.MaxInstances, parallelism, cache, stream)
+
+	for msg := range stream {
+		switch msg := msg.(type) {
+		case instancesPageMsg:
+			instances = append(instances, msg.instances...)
+		case ssmStatusMsg:
+			applySSMStatus(instances, msg)
+		case instancesLoadedMsg:
+			warnings = msg.warnings
+		case errorMsg:
+			return nil, nil, errors.New(msg.err)
+		}
+	}
+	sortInstances(instances)
+	return instances, warnings, nil
+}
+
+// findInstance finds the running instance with the given ID, or the only
+// running instance with the given Name tag
+func findInstance(instances []EC2Instance, query string) (EC2Instance, error) {
+	var named []EC2Instance
+	for _, inst := range instances {
+		if inst.State != "running" {
+			continue
+		}
+		if inst.ID == query {
+			return inst, nil
+		}
+		if inst.Name == query {
+			named = append(named, inst)
+		}
+	}
+
+	switch len(named) {
+	case 0:
+		return EC2Instance{}, fmt.Errorf("no running instance is named %q or has that ID", query)
+	case 1:
+		return named[0], nil
+	}
+	var ids []string
+	for _, inst := range named {
+		ids = append(ids, inst.ID)
+	}
+	return EC2Instance{}, fmt.Errorf("%d running instances are named %q (use one of %s)", len(named), query, strings.Join(ids, ", "))
+}
+
+// matchInstances finds the running instances a query names: those with the
+// tag for "tag:Key=Value", else the instance with that ID, else those named
+// exactly that, else those the browser's search would list
+func matchInstances(instances []EC2Instance, query string) ([]EC2Instance, error) {
+	var running []EC2Instance
+	for _, inst := range instances {
+		if inst.State == "running" {
+			running = append(running, inst)
+		}
+	}
+
+	if spec, ok := strings.CutPrefix(query, "tag:"); ok {
+		key, value, ok := strings.Cut(spec, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid tag query %q (use tag:Key=Value)", query)
+		}
+		var tagged []EC2Instance
+		for _, inst := range running {
+			if v, ok := inst.Tags[key]; ok && v == value {
+				tagged = append(tagged, inst)
+			}
+		}
+		return tagged, nil
+	}
+
+	var named, matched []EC2Instance
+	for _, inst := range running {
+		if inst.ID == query {
+			return []EC2Instance{inst}, nil
+		}
+		if inst.Name == query {
+			named = append(named, inst)
+		}
+		if searchMatch(query, inst) {
+			matched = append(matched, inst)
+		}
+	}
+	if len(named) > 0 {
+		return named, nil
+	}
+	return matched, nil
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Instance actions available from the TUI
+const (
+	actionStart  = "start"
+	actionStop   = "stop"
+	actionReboot = "reboot"
+)
+
+// Polling of an instance after an action, until its state settles
+const (
+	statePollInterval = 3 * time.Second
+	statePollTimeout  = 10 * time.Minute
+	awsCallTimeout    = 30 * time.Second
+)
+
+// instanceActionMsg reports the result of a start, stop or reboot request
+type instanceActionMsg struct {
+	inst   EC2Instance
+	action string
+	err    error
+}
+
+// instanceStateMsg carries a freshly described instance. If settled is
+// false, polling continues until deadline.
+type instanceStateMsg struct {
+	inst     EC2Instance
+	settled  bool
+	deadline time.Time
+	err      error
+}
+
+// instanceAWSConfig loads the AWS config for the profile and region an
+// instance was discovered in
+func instanceAWSConfig(ctx context.Context, inst EC2Instance) (aws.Config, error) {
+	cfg, err := awsconfig.LoadDefaultConfig(ctx,
+		awsconfig.WithSharedConfigProfile(inst.Profile),
+		awsconfig.WithRegion(inst.Region),
+	)
+	if err != nil {
+		return aws.Config{}, fmt.Errorf("failed to load AWS config: %w", err)
+	}
+	return cfg, nil
+}
+
+// runInstanceAction returns a command that starts, stops or reboots inst
+func runInstanceAction(inst EC2Instance, action string) tea.Cmd {
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), awsCallTimeout)
+		defer cancel()
+
+		cfg, err := instanceAWSConfig(ctx, inst)
+		if err != nil {
+			return instanceActionMsg{inst: inst, action: action, err: err}
+		}
+		client := ec2.NewFromConfig(cfg)
+		ids := []string{inst.ID}
+
+		switch action {
+		case actionStart:
+			_, err = client.StartInstances(ctx, &ec2.StartInstancesInput{InstanceIds: ids})
+		case actionStop:
+			_, err = client.StopInstances(ctx, &ec2.StopInstancesInput{InstanceIds: ids})
+		case actionReboot:
+			_, err = client.RebootInstances(ctx, &ec2.RebootInstancesInput{InstanceIds: ids})
+		default:
+			err = fmt.Errorf("unknown action %q", action)
+		}
+		return instanceActionMsg{inst: inst, action: action, err: err}
+	}
+}
+
+// describeInstance fetches the current state of inst. Fields assigned during
+// discovery (profile, account, region, environment, SSM status) are kept.
+func describeInstance(ctx context.Context, inst EC2Instance) (EC2Instance, error) {
+	cfg, err := instanceAWSConfig(ctx, inst)
+	if err != nil {
+		return inst, err
+	}
+
+	resp, err := ec2.NewFromConfig(cfg).DescribeInstances(ctx, &ec2.DescribeInstancesInput{
+		InstanceIds: []string{inst.ID},
+	})
+	if err != nil {
+		return inst, err
+	}
+	for _, res := range resp.Reservations {
+		for _, found := range res.Instances {
+			fresh := newEC2Instance(found)
+			fresh.Profile = inst.Profile
+			fresh.AccountID = inst.AccountID
+			fresh.Account = inst.Account
+			fresh.Region = inst.Region
+			fresh.Environment = inst.Environment
+			fresh.SSMStatus = inst.SSMStatus
+			return fresh, nil
+		}
+	}
+	return inst, fmt.Errorf("%s not found", inst.ID)
+}
+
+// pollInstanceState returns a command that describes inst after delay and
+// reports whether it reached a settled state (running or stopped)
+func pollInstanceState(inst EC2Instance, delay time.Duration, deadline time.Time) tea.Cmd {
+	return tea.Tick(delay, func(time.Time) tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), awsCallTimeout)
+		defer cancel()
+
+		fresh, err := describeInstance(ctx, inst)
+		return instanceStateMsg{
+			inst:     fresh,
+			settled:  err == nil && settledState(fresh.State),
+			deadline: deadline,
+			err:      err,
+		}
+	})
+}
+
+// settledState reports whether an instance state no longer changes on its own
+func settledState(state string) bool {
+	switch state {
+	case "running", "stopped", "terminated":
+		return true
+	}
+	return false
+}
+
+// actionAllowed reports whether action makes sense for an instance in state
+func actionAllowed(action, state string) bool {
+	switch action {
+	case actionStart:
+		return state == "stopped"
+	case actionStop:
+		return state == "running" || state == "pending"
+	case actionReboot:
+		return state == "running"
+	}
+	return false
+}
+
+// actionProgress is the present participle shown while an action runs
+func actionProgress(action string) string {
+	switch action {
+	case actionStart:
+		return "Starting"
+	case actionStop:
+		return "Stopping"
+	case actionReboot:
+		return "Rebooting"
+	}
+	return action
+}
+
+// startConnectTimeout bounds the whole start-and-connect flow
+const startConnectTimeout = 10 * time.Minute
+
+// portProbeInterval is the pause between attempts to reach the ssh port
+const portProbeInterval = 2 * time.Second
+
+// Start-and-connect messages. Steps are streamed as they begin; the flow
+// ends with a startDoneMsg carrying the refreshed instance.
+type startStepMsg struct {
+	step   string
+	stream <-chan tea.Msg
+}
+
+type startDoneMsg struct {
+	inst   EC2Instance
+	err    error
+	stream <-chan tea.Msg
+}
+
+// waitForStart returns a command that reads the next message of a
+// start-and-connect flow
+func waitForStart(stream <-chan tea.Msg) tea.Cmd {
+	return func() tea.Msg {
+		return <-stream
+	}
+}
+
+// startInstance starts inst, waits until it is running, refreshes it to pick
+// up its new addresses and, if probeAddress returns a host:port for the
+// refreshed instance, waits until that port accepts connections. Progress is
+// sent to stream, which must have room for every message so that a flow the
+// TUI stopped listening to does not block.
+func startInstance(ctx context.Context, inst EC2Instance, probeAddress func(EC2Instance) string, stream chan tea.Msg) {
+	fail := func(err error) {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s", startConnectTimeout)
+		}
+		stream <- startDoneMsg{inst: inst, err: err, stream: stream}
+	}
+
+	stream <- startStepMsg{step: "Starting " + instanceLabel(inst), stream: stream}
+	cfg, err := instanceAWSConfig(ctx, inst)
+	if err != nil {
+		fail(err)
+		return
+	}
+	client := ec2.NewFromConfig(cfg)
+	ids := []string{inst.ID}
+	if _, err := client.StartInstances(ctx, &ec2.StartInstancesInput{InstanceIds: ids}); err != nil {
+		fail(err)
+		return
+	}
+
+	stream <- startStepMsg{step: "Waiting for the instance to run", stream: stream}
+	deadline, _ := ctx.Deadline()
+	waiter := ec2.NewInstanceRunningWaiter(client)
+	if err := waiter.Wait(ctx, &ec2.DescribeInstancesInput{InstanceIds: ids}, time.Until(deadline)); err != nil {
+		fail(err)
+		return
+	}
+
+	stream <- startStepMsg{step: "Refreshing addresses", stream: stream}
+	if inst, err = describeInstance(ctx, inst); err != nil {
+		fail(err)
+		return
+	}
+
+	if addr := probeAddress(inst); addr != "" {
+		stream <- startStepMsg{step: "Waiting for " + addr + " to accept connections", stream: stream}
+		if err := waitForPort(ctx, addr); err != nil {
+			fail(err)
+			return
+		}
+	}
+
+	stream <- startDoneMsg{inst: inst, stream: stream}
+}
+
+// waitForPort dials addr until it accepts a TCP connection
+func waitForPort(ctx context.Context, addr string) error {
+	var dialer net.Dialer
+	for {
+		attemptCtx, cancel := context.WithTimeout(ctx, portProbeInterval)
+		conn, err := dialer.DialContext(attemptCtx, "tcp", addr)
+		cancel()
+		if err == nil {
+			return conn.Close()
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(portProbeInterval):
+		}
+	}
+}
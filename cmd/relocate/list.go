@@ -0,0 +1,348 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"os"
+	"slices"
+	"strings"
+	"text/tabwriter"
+	"text/template"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// List output formats
+const (
+	listTable = "table"
+	listJSON  = "json"
+	listJSONL = "jsonl"
+	listCSV   = "csv"
+	listTSV   = "tsv"
+	listYAML  = "yaml"
+)
+
+// listFormats lists the output formats of relocate list
+var listFormats = []string{listTable, listJSON, listJSONL, listCSV, listTSV, listYAML}
+
+// listColumn is a field of the instances relocate list prints
+type listColumn struct {
+	name  string
+	value func(inst EC2Instance) any // a string, or a map for tags
+}
+
+// listColumns lists every column in the order of a full record
+var listColumns = []listColumn{
+	{"id", func(i EC2Instance) any { return i.ID }},
+	{"name", func(i EC2Instance) any { return i.Name }},
+	{"environment", func(i EC2Instance) any { return i.Environment }},
+	{"state", func(i EC2Instance) any { return i.State }},
+	{"type", func(i EC2Instance) any { return i.Type }},
+	{"private-ip", func(i EC2Instance) any { return i.PrivateIP }},
+	{"public-ip", func(i EC2Instance) any { return i.PublicIP }},
+	{"ipv6", func(i EC2Instance) any { return i.IPv6 }},
+	{"private-dns", func(i EC2Instance) any { return i.PrivateDNS }},
+	{"public-dns", func(i EC2Instance) any { return i.PublicDNS }},
+	{"profile", func(i EC2Instance) any { return i.Profile }},
+	{"account", func(i EC2Instance) any { return i.Account }},
+	{"account-id", func(i EC2Instance) any { return i.AccountID }},
+	{"region", func(i EC2Instance) any { return i.Region }},
+	{"zone", func(i EC2Instance) any { return i.Zone }},
+	{"vpc", func(i EC2Instance) any { return i.VpcID }},
+	{"key-name", func(i EC2Instance) any { return i.KeyName }},
+	{"ami", func(i EC2Instance) any { return i.AMI }},
+	{"ssm", func(i EC2Instance) any { return i.SSMStatus }},
+	{"tags", func(i EC2Instance) any {
+		if i.Tags == nil {
+			return map[string]string{}
+		}
+		return i.Tags
+	}},
+}
+
+// defaultListColumns are the columns of the table, CSV and TSV formats
+// without --columns
+var defaultListColumns = []string{"name", "id", "environment", "state", "type", "private-ip", "public-ip", "region", "profile"}
+
+// parseListColumns resolves column names. "tag:Key" is a column holding the
+// value of that tag.
+func parseListColumns(names []string) ([]listColumn, error) {
+	var columns []listColumn
+	for _, name := range names {
+		name = strings.TrimSpace(name)
+		if key, ok := strings.CutPrefix(name, "tag:"); ok && key != "" {
+			columns = append(columns, listColumn{name, func(i EC2Instance) any { return i.Tags[key] }})
+			continue
+		}
+		i := slices.IndexFunc(listColumns, func(c listColumn) bool { return c.name == name })
+		if i < 0 {
+			var known []string
+			for _, c := range listColumns {
+				known = append(known, c.name)
+			}
+			return nil, fmt.Errorf("unknown column %q (use %s or tag:Key)", name, strings.Join(known, ", "))
+		}
+		columns = append(columns, listColumns[i])
+	}
+	return columns, nil
+}
+
+// listOptions are the flags of relocate list
+type listOptions struct {
+	format    string
+	columns   []string // nil for the format's default
+	query     string   // search as typed in the browser
+	template  string   // text/template run for each instance, instead of format
+	allStates bool     // list stopped and transitional instances too
+}
+
+// runList prints the inventory, or the instances the query matches, in the
+// chosen format. It fails when no instance is listed.
+func runList(overrides config.Overrides, filterTag string, offline bool, opts listOptions) error {
+	if !slices.Contains(listFormats, opts.format) {
+		return fmt.Errorf("unknown output format %q (use %s)", opts.format, strings.Join(listFormats, ", "))
+	}
+	var tmpl *template.Template
+	if opts.template != "" {
+		var err error
+		if tmpl, err = template.New("list").Option("missingkey=zero").Parse(opts.template); err != nil {
+			return err
+		}
+	}
+	names := opts.columns
+	if names == nil {
+		names = defaultListColumns
+		if opts.format == listJSON || opts.format == listJSONL || opts.format == listYAML {
+			// Records are complete unless columns are picked
+			names = nil
+			for _, c := range listColumns {
+				names = append(names, c.name)
+			}
+		}
+	}
+	columns, err := parseListColumns(names)
+	if err != nil {
+		return err
+	}
+
+	instances, warnings, err := fetchInventory(overrides, filterTag, offline)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	var listed []EC2Instance
+	for _, inst := range instances {
+		if (opts.allStates || inst.State == "running") && searchMatch(opts.query, inst) {
+			listed = append(listed, inst)
+		}
+	}
+	if len(listed) == 0 {
+		return errors.New("no instances match")
+	}
+
+	if tmpl != nil {
+		return writeTemplate(os.Stdout, tmpl, listed)
+	}
+	switch opts.format {
+	case listJSON, listJSONL:
+		return writeJSON(os.Stdout, columns, listed, opts.format == listJSONL)
+	case listYAML:
+		return writeYAML(os.Stdout, columns, listed)
+	case listCSV:
+		return writeCSV(os.Stdout, columns, listed)
+	case listTSV:
+		return writeTSV(os.Stdout, columns, listed)
+	}
+	return writeTable(os.Stdout, columns, listed)
+}
+
+// cellValue formats a column value for the table, CSV and TSV formats
+func cellValue(value any) string {
+	tags, ok := value.(map[string]string)
+	if !ok {
+		return value.(string)
+	}
+	var pairs []string
+	for _, key := range slices.Sorted(maps.Keys(tags)) {
+		pairs = append(pairs, key+"="+tags[key])
+	}
+	return strings.Join(pairs, ",")
+}
+
+// writeTable writes instances as aligned columns under a header
+func writeTable(w io.Writer, columns []listColumn, instances []EC2Instance) error {
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	var header []string
+	for _, c := range columns {
+		header = append(header, strings.ToUpper(c.name))
+	}
+	fmt.Fprintln(tw, strings.Join(header, "\t"))
+	for _, inst := range instances {
+		var cells []string
+		for _, c := range columns {
+			cell := tsvField(cellValue(c.value(inst)))
+			if cell == "" {
+				cell = "-"
+			}
+			cells = append(cells, cell)
+		}
+		fmt.Fprintln(tw, strings.Join(cells, "\t"))
+	}
+	return tw.Flush()
+}
+
+// writeCSV writes instances as CSV with a header row
+func writeCSV(w io.Writer, columns []listColumn, instances []EC2Instance) error {
+	cw := csv.NewWriter(w)
+	var header []string
+	for _, c := range columns {
+		header = append(header, c.name)
+	}
+	cw.Write(header)
+	for _, inst := range instances {
+		var record []string
+		for _, c := range columns {
+			record = append(record, cellValue(c.value(inst)))
+		}
+		cw.Write(record)
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// writeTSV writes instances as tab separated values with a header row
+func writeTSV(w io.Writer, columns []listColumn, instances []EC2Instance) error {
+	var b strings.Builder
+	var header []string
+	for _, c := range columns {
+		header = append(header, c.name)
+	}
+	b.WriteString(strings.Join(header, "\t") + "\n")
+	for _, inst := range instances {
+		var fields []string
+		for _, c := range columns {
+			fields = append(fields, tsvField(cellValue(c.value(inst))))
+		}
+		b.WriteString(strings.Join(fields, "\t") + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// tsvField replaces the tabs and line breaks a field cannot hold with spaces
+func tsvField(s string) string {
+	return strings.NewReplacer("\t", " ", "\n", " ", "\r", " ").Replace(s)
+}
+
+// listRecord is an instance as a JSON object with its fields in column order
+type listRecord struct {
+	columns []listColumn
+	inst    EC2Instance
+}
+
+func (r listRecord) MarshalJSON() ([]byte, error) {
+	var b bytes.Buffer
+	b.WriteByte('{')
+	for i, c := range r.columns {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, _ := json.Marshal(c.name)
+		value, err := json.Marshal(c.value(r.inst))
+		if err != nil {
+			return nil, err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.Bytes(), nil
+}
+
+// writeJSON writes instances as an indented JSON array, or as one compact
+// object per line for JSON Lines
+func writeJSON(w io.Writer, columns []listColumn, instances []EC2Instance, lines bool) error {
+	var records []listRecord
+	for _, inst := range instances {
+		records = append(records, listRecord{columns, inst})
+	}
+	if lines {
+		enc := json.NewEncoder(w)
+		for _, record := range records {
+			if err := enc.Encode(record); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	data, err := json.MarshalIndent(records, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(data, '\n'))
+	return err
+}
+
+// writeYAML writes instances as a YAML sequence of mappings. Strings are
+// written JSON quoted, which YAML reads as double-quoted scalars.
+func writeYAML(w io.Writer, columns []listColumn, instances []EC2Instance) error {
+	var b strings.Builder
+	for _, inst := range instances {
+		for i, c := range columns {
+			prefix := "  "
+			if i == 0 {
+				prefix = "- "
+			}
+			key := c.name
+			if strings.HasPrefix(key, "tag:") {
+				// Tag keys may hold anything
+				quoted, _ := json.Marshal(key)
+				key = string(quoted)
+			}
+			b.WriteString(prefix + key + ":")
+			tags, ok := c.value(inst).(map[string]string)
+			if !ok {
+				value, _ := json.Marshal(c.value(inst))
+				b.WriteString(" " + string(value) + "\n")
+				continue
+			}
+			if len(tags) == 0 {
+				b.WriteString(" {}\n")
+				continue
+			}
+			b.WriteString("\n")
+			for _, key := range slices.Sorted(maps.Keys(tags)) {
+				k, _ := json.Marshal(key)
+				v, _ := json.Marshal(tags[key])
+				b.WriteString("    " + string(k) + ": " + string(v) + "\n")
+			}
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// writeTemplate runs tmpl for each instance, one per line
+func writeTemplate(w io.Writer, tmpl *template.Template, instances []EC2Instance) error {
+	for _, inst := range instances {
+		var b bytes.Buffer
+		if err := tmpl.Execute(&b, inst); err != nil {
+			return err
+		}
+		if !bytes.HasSuffix(b.Bytes(), []byte("\n")) {
+			b.WriteByte('\n')
+		}
+		if _, err := w.Write(b.Bytes()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
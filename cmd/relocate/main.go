@@ -1,19 +1,17 @@
 package main
 
 import (
+	"cmp"
 	"context"
+	"errors"
 	"fmt"
+	"net"
 	"os"
-	"os/exec"
-	"path/filepath"
 	"slices"
+	"strconv"
 	"strings"
 	"time"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/service/ec2"
-	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
 	"github.com/urfave/cli/v2"
@@ -62,6 +60,23 @@ var (
 // Pre-rendered indicators
 var runningDot = lipgloss.NewStyle().Foreground(successColor).Render("●")
 var stoppedDot = lipgloss.NewStyle().Foreground(dimColor).Render("●")
+var pendingDot = lipgloss.NewStyle().Foreground(warningColor).Render("●")
+
+// Markers of instances that changed since the previous refresh
+var changeMarkers = map[string]string{
+	changeAdded:   lipgloss.NewStyle().Foreground(successColor).Render("+"),
+	changeRemoved: lipgloss.NewStyle().Foreground(errorColor).Render("−"),
+	changeState:   lipgloss.NewStyle().Foreground(warningColor).Render("~"),
+}
+
+// Marker of starred instances
+var favouriteMarker = lipgloss.NewStyle().Foreground(warningColor).Render("★")
+
+// Marker of instances marked for a broadcast command
+var markedMarker = lipgloss.NewStyle().Foreground(primaryColor).Render("■")
+
+// Loading spinner frames, advanced on every tick
+var spinnerFrames = []string{"◜", "◠", "◝", "◞"}
 
 // Dynamic style builders based on terminal size
 func (m model) titleBarStyle() lipgloss.Style {
@@ -164,14 +179,28 @@ var appConfig config.Config
 
 // EC2Instance represents an EC2 instance
 type EC2Instance struct {
-	ID      string
-	Name    string
-	IP      string
-	State   string
-	Type    string
-	Zone    string
-	KeyName string
-	AMI     string
+	ID          string
+	Name        string
+	IP          string // public IP, else the private IP, else IPv6
+	PublicIP    string
+	PrivateIP   string
+	IPv6        string
+	PublicDNS   string
+	PrivateDNS  string
+	State       string
+	Type        string
+	Profile     string
+	AccountID   string
+	Account     string // alias from account_aliases, or the account ID
+	Region      string
+	Zone        string
+	VpcID       string
+	KeyName     string
+	AMI         string
+	Tags        map[string]string
+	Environment string // assigned by config.Classify, or config.Unclassified
+	SSMStatus   string // SSM agent ping status; "" until known
+	Change      string // change since the previous load, set by a refresh
 }
 
 // viewMode represents UI states
@@ -180,33 +209,83 @@ type viewMode int
 const (
 	viewNormal viewMode = iota
 	viewConfirm
+	viewAction   // confirming a start, stop or reboot
+	viewStarting // starting an instance before connecting to it
+	viewCommand  // typing a command to run on the marked instances
+	viewResults  // output of a broadcast command
+	viewTmux     // confirming opening instances in tmux
+	viewForward  // picking a forward to open a tunnel with
+	viewTunnels  // open tunnels
+	viewTransfer // copying files to or from an instance
 )
 
 // Model for BubbleTea
 type model struct {
-	instances   []EC2Instance
-	filtered    []EC2Instance
-	cursor      int
-	selected    bool
-	loading     bool
-	err         string
-	profile     string
-	region      string
-	filterTag   string
-	searchQuery string
-	envMode     string // "staging" or "prod"
-	mode        viewMode
-	spinnerIdx  int
-	lastUpdate  time.Time
-	width       int // terminal width
-	height      int // terminal height
+	instances    []EC2Instance
+	filtered     []EC2Instance
+	cursor       int
+	selected     bool
+	loading      bool
+	refreshing   bool            // discovery is re-running in the background
+	incoming     []EC2Instance   // inventory collected by the running refresh
+	uncached     map[string]bool // profile/region targets missing from the cached inventory shown
+	watch        time.Duration   // refresh interval, 0 to refresh only on demand
+	cache        *inventoryCache // nil when caching is disabled
+	offline      bool            // show the cached inventory only
+	err          string
+	warnings     []string
+	overrides    config.Overrides   // connection settings from CLI flags
+	target       EC2Instance        // instance being connected to
+	transport    string             // transport for the connection being confirmed
+	address      string             // address kind for the connection being confirmed
+	allStates    bool               // list stopped and transitional instances too
+	recent       bool               // list recently connected instances, by frecency
+	matches      map[string]bool    // instances matched by relocate ssh, by ID; listed instead of an environment while set
+	matchQuery   string             // query the matches were found with
+	command      []string           // remote command run instead of a shell, from relocate ssh
+	history      []historyEntry     // past connections, oldest first
+	frecency     map[string]float64 // history score by instance ID
+	favourites   favourites         // starred instances, pinned to the top
+	marked       map[string]bool    // instances a broadcast command runs on, by ID
+	commandInput string             // broadcast command being typed
+	broadcast    broadcastRun       // last broadcast command
+	tmuxLayout   string             // layout for opening instances in tmux
+	tmuxSync     bool               // synchronize the panes of a tiled tmux window
+	tmuxSession  string             // new tmux session to attach to once the browser closes
+	tunnels      []*tunnel          // tunnels opened from the browser, newest last
+	tunnelInst   EC2Instance        // instance a tunnel is being opened through
+	forwardIdx   int                // forward preset picked for the tunnel
+	tunnelIdx    int                // tunnel selected in the tunnels view
+	transfer     transferRun        // file transfer being set up or run
+	action       string             // instance action being confirmed
+	actionInst   EC2Instance        // instance the action applies to
+	actionInput  string             // typed confirmation in protected environments
+	connectAfter bool               // connect once the started instance is reachable
+	startSteps   []string           // progress of the start-and-connect flow
+	startErr     string             // why the start-and-connect flow failed
+	startCancel  context.CancelFunc // cancels the start-and-connect flow
+	startStream  <-chan tea.Msg     // progress of the start-and-connect flow shown
+	notice       string             // result of the last instance action
+	noticeErr    bool
+	session      string // outcome of the previous session in loop mode
+	sessionErr   bool
+	sources      []discoverySource
+	discovery    context.Context // cancelled when the program showing the model exits
+	profiles     []string
+	profileIdx   int // 0 shows every profile, i > 0 only profiles[i-1]
+	regions      []string
+	filterTag    string
+	searchQuery  string
+	searchIdx    int    // 0 for a typed search, i > 0 recalls saved search i-1
+	envMode      string // environment name, or config.Unclassified
+	mode         viewMode
+	spinnerIdx   int
+	lastUpdate   time.Time
+	width        int // terminal width
+	height       int // terminal height
 }
 
 // Messages
-type instancesLoadedMsg struct {
-	instances []EC2Instance
-}
-
 type errorMsg struct {
 	err string
 }
@@ -234,34 +313,213 @@ func fuzzyMatch(query, target string) bool {
 	return queryIdx == len(query)
 }
 
-func initialModel(profile, region, filterTag string) model {
-	// Apply defaults from config if not provided
-	if profile == "" && appConfig.Defaults.AWSProfile != "" {
-		profile = appConfig.Defaults.AWSProfile
+// searchMatch reports whether the search query fuzzy matches the name, ID,
+// IP addresses or type of inst
+func searchMatch(query string, inst EC2Instance) bool {
+	return fuzzyMatch(query, inst.Name) ||
+		fuzzyMatch(query, inst.ID) ||
+		fuzzyMatch(query, inst.PublicIP) ||
+		fuzzyMatch(query, inst.PrivateIP) ||
+		fuzzyMatch(query, inst.Type)
+}
+
+// sessionLoop reports whether the browser comes back after every session:
+// the --loop flag, else session_loop from config
+func sessionLoop(ctx *cli.Context) bool {
+	if ctx.IsSet("loop") {
+		return ctx.Bool("loop")
+	}
+	return appConfig.SessionLoop
+}
+
+// browse runs the instance browser and the sessions started from it. In
+// loop mode the browser comes back after every session. It returns the last
+// session, if one ran.
+func browse(initial model, loop bool) (*sessionResult, error) {
+	finalModel, err := runBrowser(initial)
+	if err != nil {
+		return nil, err
+	}
+
+	var last *sessionResult
+	for {
+		m := finalModel.(model)
+		if m.tmuxSession != "" {
+			err := attachTmux(m.tmuxSession)
+			if !loop {
+				return last, err
+			}
+			m.tmuxSession = ""
+			finalModel, err = runBrowser(m.reopen())
+			if err != nil {
+				return last, err
+			}
+			continue
+		}
+		if !m.selected {
+			return last, nil
+		}
+
+		result := runSession(m)
+		last = &result
+		if err := recordSession(result); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		if !loop {
+			return last, result.err
+		}
+
+		finalModel, err = runBrowser(m.resume(result))
+		if err != nil {
+			return last, err
+		}
+	}
+}
+
+// runBrowser shows the instance browser until it quits. Discovery it
+// started is stopped when it does, so that none is left waiting to deliver
+// pages nobody reads.
+func runBrowser(m model) (tea.Model, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	m.discovery = ctx
+	return tea.NewProgram(m, tea.WithAltScreen()).Run()
+}
+
+// flagOverrides collects the connection settings given on the command line
+func flagOverrides(ctx *cli.Context) config.Overrides {
+	return config.Overrides{
+		SSHUser:    ctx.String("user"),
+		SSHPort:    ctx.Int("port"),
+		SSHKeyPath: ctx.String("identity"),
+		SSHOptions: ctx.StringSlice("ssh-option"),
+		AWSProfile: ctx.String("profile"),
+		AWSRegion:  ctx.String("region"),
+		Transport:  ctx.String("transport"),
+		Auth:       ctx.String("auth"),
+		Address:    splitList(ctx.String("address")),
+	}
+}
+
+// validateFlags rejects unknown transports, auth methods and address kinds
+func validateFlags(ctx *cli.Context) error {
+	if t := ctx.String("transport"); t != "" && !slices.Contains(config.Transports, t) {
+		return fmt.Errorf("unknown transport %q (use %s)", t, strings.Join(config.Transports, ", "))
+	}
+	if a := ctx.String("auth"); a != "" && !slices.Contains(config.AuthMethods, a) {
+		return fmt.Errorf("unknown auth %q (use %s)", a, strings.Join(config.AuthMethods, ", "))
+	}
+	for _, a := range splitList(ctx.String("address")) {
+		if !slices.Contains(config.AddressKinds, a) {
+			return fmt.Errorf("unknown address %q (use %s)", a, strings.Join(config.AddressKinds, ", "))
+		}
+	}
+	return nil
+}
+
+func initialModel(overrides config.Overrides, filterTag string, watch time.Duration, offline bool) (model, error) {
+	sources, err := discoverySources(overrides)
+	if err != nil {
+		return model{}, err
+	}
+
+	ttl, err := appConfig.CacheTTL()
+	if err != nil {
+		return model{}, err
 	}
-	if region == "" && appConfig.Defaults.AWSRegion != "" {
-		region = appConfig.Defaults.AWSRegion
+	var cache *inventoryCache
+	if ttl > 0 || offline {
+		if cache, err = newInventoryCache(filterTag); err != nil {
+			return model{}, err
+		}
+	}
+
+	var profiles, regions []string
+	for _, source := range sources {
+		profiles = append(profiles, source.profile)
+		for _, r := range source.regions {
+			if !slices.Contains(regions, r) {
+				regions = append(regions, r)
+			}
+		}
 	}
 
-	return model{
+	m := model{
 		loading:    true,
 		cursor:     0,
-		profile:    profile,
-		region:     region,
+		overrides:  overrides,
+		sources:    sources,
+		profiles:   profiles,
+		regions:    regions,
 		filterTag:  filterTag,
-		envMode:    "staging",
+		watch:      watch,
+		cache:      cache,
+		offline:    offline,
+		envMode:    appConfig.EnvironmentList()[0].Name,
 		mode:       viewNormal,
 		spinnerIdx: 0,
 		lastUpdate: time.Now(),
 		width:      80,
 		height:     24,
 	}
+
+	// Past connections rank search results and fill the recent view
+	if m.history, err = loadHistory(); err != nil {
+		m.warnings = append(m.warnings, "history: "+err.Error())
+	}
+	m.frecency = frecencyScores(m.history, time.Now())
+	if m.favourites, err = loadFavourites(); err != nil {
+		m.warnings = append(m.warnings, "favourites: "+err.Error())
+	}
+
+	// Show the cached inventory right away and reconcile it with a
+	// background refresh; offline, only the cache is shown
+	if cache != nil {
+		maxAge := ttl
+		if offline {
+			maxAge = 0
+		}
+		instances, asOf, missing := loadCached(cache, sources, maxAge)
+		if offline {
+			if len(instances) == 0 && len(missing) > 0 {
+				return model{}, fmt.Errorf("no cached inventory for %s (run relocate without --offline first)", strings.Join(missing, ", "))
+			}
+			for _, source := range missing {
+				m.warnings = append(m.warnings, source+": not cached")
+			}
+		}
+		if !asOf.IsZero() {
+			m.instances = instances
+			m.loading = false
+			m.refreshing = !offline
+			m.lastUpdate = asOf
+			m.filterInstances()
+			if offline && len(missing) == 0 {
+				m.repinFavourites()
+			}
+
+			// Their instances are not new to the refresh, just not cached
+			m.uncached = make(map[string]bool)
+			for _, target := range missing {
+				m.uncached[target] = true
+			}
+		}
+	}
+
+	return m, nil
 }
 
 func (m model) Init() tea.Cmd {
+	if m.offline {
+		return tea.EnterAltScreen
+	}
+	if !m.loading && !m.refreshing {
+		// Back from a session with the inventory loaded
+		return tea.Batch(tea.EnterAltScreen, watchTick(m.watch))
+	}
 	return tea.Batch(
 		tea.EnterAltScreen,
-		loadInstances(m.profile, m.region, m.filterTag),
+		loadInstances(m.discovery, m.sources, m.filterTag, m.cache),
 		tick(),
 	)
 }
@@ -275,6 +533,44 @@ func tick() tea.Cmd {
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.mode == viewAction {
+			return m.updateAction(msg)
+		}
+		if m.mode == viewCommand {
+			return m.updateBroadcast(msg)
+		}
+		if m.mode == viewResults {
+			return m.updateResults(msg)
+		}
+		if m.mode == viewTmux {
+			return m.updateTmux(msg)
+		}
+		if m.mode == viewForward {
+			return m.updateForward(msg)
+		}
+		if m.mode == viewTunnels {
+			return m.updateTunnels(msg)
+		}
+		if m.mode == viewTransfer {
+			return m.updateTransfer(msg)
+		}
+
+		if m.mode == viewStarting {
+			switch msg.Type {
+			case tea.KeyEsc:
+				if m.startErr == "" {
+					m.notice = fmt.Sprintf("Stopped waiting for %s; it keeps starting", instanceLabel(m.actionInst))
+					m.noticeErr = false
+				}
+				m.startCancel()
+				m.mode = viewNormal
+			case tea.KeyCtrlC:
+				m.startCancel()
+				return m, tea.Quit
+			}
+			return m, nil
+		}
+
 		if m.mode == viewConfirm {
 			if msg.String() == "y" || msg.String() == "Y" || msg.String() == " " {
 				m.selected = true
@@ -284,6 +580,13 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.mode = viewNormal
 				return m, nil
 			}
+			if msg.String() == "t" || msg.String() == "T" {
+				i := slices.Index(config.Transports, m.transport)
+				m.transport = config.Transports[(i+1)%len(config.Transports)]
+			}
+			if msg.String() == "a" || msg.String() == "A" {
+				m.address = nextAddress(m.target, m.address)
+			}
 			return m, nil
 		}
 
@@ -294,6 +597,12 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		case tea.KeyEsc:
 			if m.searchQuery != "" {
 				m.searchQuery = ""
+				m.searchIdx = 0
+				m.filterInstances()
+				m.cursor = 0
+			} else if m.matches != nil {
+				// Back to browsing environments
+				m.matches = nil
 				m.filterInstances()
 				m.cursor = 0
 			} else {
@@ -301,10 +610,30 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			}
 
 		case tea.KeyEnter:
-			if m.loading || m.err != "" || len(m.filtered) == 0 {
+			if m.err != "" || len(m.filtered) == 0 {
+				return m, nil
+			}
+			inst := m.filtered[m.cursor]
+			if inst.Change == changeRemoved {
+				m.notice = fmt.Sprintf("Cannot connect to %s: instance no longer exists", instanceLabel(inst))
+				m.noticeErr = true
 				return m, nil
 			}
-			m.mode = viewConfirm
+			switch inst.State {
+			case "running":
+				m.selectTarget(inst)
+				m.mode = viewConfirm
+			case "stopped":
+				// Offer to start the instance and connect once it is up
+				m.action = actionStart
+				m.actionInst = inst
+				m.actionInput = ""
+				m.connectAfter = true
+				m.mode = viewAction
+			default:
+				m.notice = fmt.Sprintf("Cannot connect to %s: instance is %s", instanceLabel(inst), inst.State)
+				m.noticeErr = true
+			}
 
 		case tea.KeyUp, tea.KeyDown:
 			if msg.Type == tea.KeyUp && m.cursor > 0 {
@@ -314,18 +643,190 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				m.cursor++
 			}
 
-		case tea.KeyTab:
-			if m.envMode == "staging" {
-				m.envMode = "prod"
+		case tea.KeyTab, tea.KeyShiftTab:
+			modes := envModes()
+			step := 1
+			if msg.Type == tea.KeyShiftTab {
+				step = len(modes) - 1
+			}
+			m.envMode = modes[(slices.Index(modes, m.envMode)+step)%len(modes)]
+			m.recent = false
+			m.matches = nil
+			m.filterInstances()
+			m.cursor = 0
+
+		case tea.KeyCtrlR:
+			return m.refresh()
+
+		case tea.KeyCtrlA:
+			m.allStates = !m.allStates
+			m.filterInstances()
+			m.cursor = 0
+
+		case tea.KeySpace:
+			if m.err != "" || len(m.filtered) == 0 {
+				return m, nil
+			}
+			id := m.filtered[m.cursor].ID
+			if m.marked[id] {
+				delete(m.marked, id)
+			} else {
+				if m.marked == nil {
+					m.marked = make(map[string]bool)
+				}
+				m.marked[id] = true
+			}
+			if m.cursor < len(m.filtered)-1 {
+				m.cursor++
+			}
+
+		case tea.KeyCtrlO:
+			if m.err != "" || len(m.markedInstances()) == 0 {
+				m.notice = "Mark running instances with Space (or * for all) to run a command on them"
+				m.noticeErr = true
+				return m, nil
+			}
+			m.commandInput = ""
+			m.mode = viewCommand
+
+		case tea.KeyCtrlW:
+			if m.err != "" || len(m.markedInstances()) == 0 {
+				m.notice = "Mark running instances with Space (or * for all) to open them in tmux"
+				m.noticeErr = true
+				return m, nil
+			}
+			if !tmuxAvailable() {
+				m.notice = errNoTmux.Error()
+				m.noticeErr = true
+				return m, nil
+			}
+			m.tmuxLayout = appConfig.TmuxLayout()
+			m.tmuxSync = appConfig.Tmux.Synchronize
+			m.mode = viewTmux
+
+		case tea.KeyCtrlL:
+			if m.err != "" || len(m.filtered) == 0 {
+				return m, nil
+			}
+			if len(appConfig.Forwards) == 0 {
+				m.notice = "No forwards configured (add them to forwards in ~/.relocate/config.json)"
+				m.noticeErr = true
+				return m, nil
+			}
+			inst := m.filtered[m.cursor]
+			if inst.State != "running" || inst.Change == changeRemoved {
+				m.notice = fmt.Sprintf("Cannot open a tunnel through %s: instance is not running", instanceLabel(inst))
+				m.noticeErr = true
+				return m, nil
+			}
+			m.tunnelInst = inst
+			m.forwardIdx = 0
+			m.mode = viewForward
+
+		case tea.KeyCtrlU:
+			if m.err != "" || len(m.filtered) == 0 {
+				return m, nil
+			}
+			inst := m.filtered[m.cursor]
+			if inst.State != "running" || inst.Change == changeRemoved {
+				m.notice = fmt.Sprintf("Cannot copy files with %s: instance is not running", instanceLabel(inst))
+				m.noticeErr = true
+				return m, nil
+			}
+			last := m.transfer
+			m.transfer = transferRun{inst: inst, upload: true, field: 1}
+			if last.inst.ID != "" {
+				// Keep the direction and paths of the last transfer, they are often reused
+				m.transfer.upload, m.transfer.local, m.transfer.remote = last.upload, last.local, last.remote
+			}
+			m.mode = viewTransfer
+
+		case tea.KeyCtrlG:
+			m.tunnelIdx = min(m.tunnelIdx, max(0, len(m.tunnels)-1))
+			m.mode = viewTunnels
+			return m, tick()
+
+		case tea.KeyCtrlE:
+			m.recent = !m.recent
+			m.matches = nil
+			m.filterInstances()
+			m.cursor = 0
+
+		case tea.KeyCtrlT:
+			if m.err != "" || len(m.filtered) == 0 {
+				return m, nil
+			}
+			inst := m.filtered[m.cursor]
+			favs, starred := m.favourites.toggle(inst)
+			if err := favs.save(); err != nil {
+				m.notice = fmt.Sprintf("Failed to save favourites: %v", err)
+				m.noticeErr = true
+				return m, nil
+			}
+			m.favourites = favs
+			m.filterInstances()
+			m.cursor = max(0, slices.IndexFunc(m.filtered, func(i EC2Instance) bool { return i.ID == inst.ID }))
+			if starred {
+				m.notice = fmt.Sprintf("Starred %s", instanceLabel(inst))
 			} else {
-				m.envMode = "staging"
+				m.notice = fmt.Sprintf("Unstarred %s", instanceLabel(inst))
+			}
+			m.noticeErr = false
+
+		case tea.KeyCtrlF:
+			searches := appConfig.SavedSearches
+			if len(searches) == 0 {
+				m.notice = "No saved searches configured"
+				m.noticeErr = true
+				return m, nil
+			}
+			m.searchIdx = (m.searchIdx + 1) % (len(searches) + 1)
+			m.searchQuery = ""
+			if m.searchIdx > 0 {
+				search := searches[m.searchIdx-1]
+				m.searchQuery = search.Query
+				m.allStates = search.AllStates
+				if search.Environment != "" {
+					m.envMode = search.Environment
+					m.recent = false
+					m.matches = nil
+				}
 			}
 			m.filterInstances()
 			m.cursor = 0
 
+		case tea.KeyCtrlS, tea.KeyCtrlX, tea.KeyCtrlK:
+			if m.err != "" || len(m.filtered) == 0 {
+				return m, nil
+			}
+			action := map[tea.KeyType]string{
+				tea.KeyCtrlS: actionStart,
+				tea.KeyCtrlX: actionStop,
+				tea.KeyCtrlK: actionReboot,
+			}[msg.Type]
+			inst := m.filtered[m.cursor]
+			if !actionAllowed(action, inst.State) {
+				m.notice = fmt.Sprintf("Cannot %s %s: instance is %s", action, instanceLabel(inst), inst.State)
+				m.noticeErr = true
+				return m, nil
+			}
+			m.action = action
+			m.actionInst = inst
+			m.actionInput = ""
+			m.connectAfter = false
+			m.mode = viewAction
+
+		case tea.KeyCtrlP:
+			if len(m.profiles) > 1 {
+				m.profileIdx = (m.profileIdx + 1) % (len(m.profiles) + 1)
+				m.filterInstances()
+				m.cursor = 0
+			}
+
 		case tea.KeyBackspace:
 			if len(m.searchQuery) > 0 {
 				m.searchQuery = m.searchQuery[:len(m.searchQuery)-1]
+				m.searchIdx = 0
 				m.filterInstances()
 				if m.cursor >= len(m.filtered) {
 					m.cursor = max(0, len(m.filtered)-1)
@@ -342,20 +843,25 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				if m.cursor < len(m.filtered)-1 {
 					m.cursor++
 				}
-			case "1":
-				if m.envMode != "staging" {
-					m.envMode = "staging"
+			case "*":
+				m.markAll()
+			case "1", "2", "3", "4", "5", "6", "7", "8", "9", "0":
+				env, ok := envHotkey(msg.String())
+				if !ok {
+					m.searchQuery += msg.String()
+					m.searchIdx = 0
 					m.filterInstances()
 					m.cursor = 0
-				}
-			case "2":
-				if m.envMode != "prod" {
-					m.envMode = "prod"
+				} else if m.envMode != env || m.recent || m.matches != nil {
+					m.envMode = env
+					m.recent = false
+					m.matches = nil
 					m.filterInstances()
 					m.cursor = 0
 				}
 			default:
 				m.searchQuery += msg.String()
+				m.searchIdx = 0
 				m.filterInstances()
 				m.cursor = 0
 			}
@@ -367,19 +873,184 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		return m, nil
 
 	case tickMsg:
-		m.spinnerIdx = (m.spinnerIdx + 1) % 4
-		if m.loading {
+		m.spinnerIdx = (m.spinnerIdx + 1) % len(spinnerFrames)
+		if m.loading || m.refreshing || (m.mode == viewStarting && m.startErr == "") || (m.mode == viewResults && m.broadcast.running) || m.mode == viewTunnels || (m.mode == viewTransfer && m.transfer.running) {
 			return m, tick()
 		}
 		return m, nil
 
-	case instancesLoadedMsg:
-		m.instances = msg.instances
+	case instancesPageMsg:
+		if m.refreshing {
+			m.incoming = append(m.incoming, msg.instances...)
+			return m, waitForDiscovery(msg.stream)
+		}
+		// Pages sort in among the instances listed; the cursor stays on
+		// the same instance
+		var selectedID string
+		if m.cursor < len(m.filtered) {
+			selectedID = m.filtered[m.cursor].ID
+		}
+		m.instances = append(m.instances, msg.instances...)
+		sortInstances(m.instances)
 		m.filterInstances()
-		m.loading = false
+		m.cursor = max(0, slices.IndexFunc(m.filtered, func(inst EC2Instance) bool { return inst.ID == selectedID }))
+		return m, waitForDiscovery(msg.stream)
+
+	case ssmStatusMsg:
+		if m.refreshing {
+			applySSMStatus(m.incoming, msg)
+			return m, waitForDiscovery(msg.stream)
+		}
+		applySSMStatus(m.instances, msg)
+		m.filterInstances()
+		return m, waitForDiscovery(msg.stream)
+
+	case refreshTickMsg:
+		return m.refresh()
+
+	case instanceActionMsg:
+		if msg.err != nil {
+			m.notice = fmt.Sprintf("Failed to %s %s: %v", msg.action, instanceLabel(msg.inst), msg.err)
+			m.noticeErr = true
+			return m, nil
+		}
+		m.notice = fmt.Sprintf("%s %s…", actionProgress(msg.action), instanceLabel(msg.inst))
+		m.noticeErr = false
+		return m, pollInstanceState(msg.inst, statePollInterval, time.Now().Add(statePollTimeout))
+
+	case instanceStateMsg:
+		if msg.err != nil {
+			m.notice = fmt.Sprintf("Failed to refresh %s: %v", instanceLabel(msg.inst), msg.err)
+			m.noticeErr = true
+			return m, nil
+		}
+		m.updateInstance(msg.inst)
+		if msg.settled {
+			m.notice = fmt.Sprintf("%s is %s", instanceLabel(msg.inst), msg.inst.State)
+			m.noticeErr = false
+			return m, nil
+		}
+		if time.Now().After(msg.deadline) {
+			m.notice = fmt.Sprintf("Gave up waiting for %s (still %s)", instanceLabel(msg.inst), msg.inst.State)
+			m.noticeErr = true
+			return m, nil
+		}
+		m.notice = fmt.Sprintf("%s is %s…", instanceLabel(msg.inst), msg.inst.State)
+		m.noticeErr = false
+		return m, pollInstanceState(msg.inst, statePollInterval, msg.deadline)
+
+	case startStepMsg:
+		// A flow whose dialog was closed is no longer listened to
+		if m.mode != viewStarting || msg.stream != m.startStream {
+			return m, nil
+		}
+		m.startSteps = append(m.startSteps, msg.step)
+		return m, waitForStart(msg.stream)
+
+	case startDoneMsg:
+		if m.mode != viewStarting || msg.stream != m.startStream {
+			return m, nil
+		}
+		m.startCancel()
+		m.updateInstance(msg.inst)
+		if msg.err != nil {
+			m.startErr = msg.err.Error()
+			return m, nil
+		}
+		m.selectTarget(msg.inst)
+		m.selected = true
+		return m, tea.Quit
+
+	case broadcastOutputMsg:
+		if msg.stream != m.broadcast.stream {
+			return m, nil
+		}
+		m.broadcast.results[msg.idx].addOutput(msg.line)
+		return m, waitForBroadcast(msg.stream)
+
+	case broadcastDoneMsg:
+		if msg.stream != m.broadcast.stream {
+			return m, nil
+		}
+		result := &m.broadcast.results[msg.idx]
+		result.done = true
+		result.exitCode = msg.exitCode
+		result.err = msg.err
+		result.duration = msg.duration
+		return m, waitForBroadcast(msg.stream)
+
+	case broadcastFinishedMsg:
+		if msg.stream == m.broadcast.stream {
+			m.broadcast.running = false
+			m.broadcast.cancel()
+		}
+		return m, nil
+
+	case tmuxOpenedMsg:
+		switch {
+		case msg.err != nil:
+			m.notice = fmt.Sprintf("Failed to open tmux: %v", msg.err)
+			m.noticeErr = true
+		case msg.session != "":
+			// Attach to the new session once the browser is closed
+			m.tmuxSession = msg.session
+			return m, tea.Quit
+		default:
+			m.notice = fmt.Sprintf("Opened %d instances in tmux", msg.count)
+			m.noticeErr = false
+		}
+		return m, nil
+
+	case tunnelOpenedMsg:
+		if msg.err != nil {
+			m.notice = fmt.Sprintf("Failed to open tunnel: %v", msg.err)
+			m.noticeErr = true
+			return m, nil
+		}
+		m.tunnels = append(m.tunnels, msg.tunnel)
+		m.notice = fmt.Sprintf("Tunnel open: %s through %s (Ctrl+G lists tunnels)", msg.tunnel.forward, instanceLabel(msg.tunnel.inst))
+		m.noticeErr = false
+		return m, nil
+
+	case transferDoneMsg:
+		run := m.transfer
+		m.transfer.running = false
+		m.mode = viewNormal
+		verb := "Downloaded"
+		if run.upload {
+			verb = "Uploaded"
+		}
+		switch {
+		case errors.Is(msg.err, context.Canceled):
+			m.notice = "Transfer cancelled"
+			m.noticeErr = true
+		case msg.err != nil:
+			m.notice = fmt.Sprintf("Transfer failed: %v", msg.err)
+			m.noticeErr = true
+		default:
+			m.notice = fmt.Sprintf("%s %d files, %s in %s", verb, run.progress.files.Load(), formatBytes(run.progress.done.Load()), time.Since(run.started).Round(100*time.Millisecond))
+			m.noticeErr = false
+		}
 		return m, nil
 
+	case instancesLoadedMsg:
+		if m.refreshing {
+			m.finishRefresh()
+		}
+		m.loading = false
+		m.warnings = msg.warnings
+		m.lastUpdate = time.Now()
+		m.repinFavourites()
+		return m, watchTick(m.watch)
+
 	case errorMsg:
+		if m.refreshing {
+			m.refreshing = false
+			m.incoming = nil
+			m.notice = "Refresh failed: " + msg.err
+			m.noticeErr = true
+			return m, watchTick(m.watch)
+		}
 		m.loading = false
 		m.err = msg.err
 		return m, nil
@@ -388,63 +1059,672 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-func (m *model) filterInstances() {
-	// First filter by environment
-	var envFiltered []EC2Instance
-	for _, inst := range m.instances {
-		// Filter instances based on KeyName matching the environment
-		if m.envMode == "staging" && strings.Contains(inst.KeyName, "staging") {
-			envFiltered = append(envFiltered, inst)
-		} else if m.envMode == "prod" && strings.Contains(inst.KeyName, "prod") {
-			envFiltered = append(envFiltered, inst)
-		}
+// refresh re-runs discovery in the background. The current list stays
+// usable until the new inventory has loaded.
+func (m model) refresh() (tea.Model, tea.Cmd) {
+	if m.offline {
+		m.notice = "Offline: showing the cached inventory"
+		m.noticeErr = false
+		return m, nil
+	}
+	if m.loading || m.refreshing {
+		return m, nil
 	}
+	m.refreshing = true
+	m.incoming = nil
+	return m, tea.Batch(loadInstances(m.discovery, m.sources, m.filterTag, m.cache), tick())
+}
 
-	// Then apply search query if present
-	if m.searchQuery == "" {
-		m.filtered = envFiltered
+// repinFavourites moves the stars of replaced instances to their
+// replacements once the inventory has loaded
+func (m *model) repinFavourites() {
+	favs, changed := m.favourites.repin(m.instances)
+	if !changed {
 		return
 	}
-
-	m.filtered = nil
-	for _, inst := range envFiltered {
-		if fuzzyMatch(m.searchQuery, inst.Name) ||
-			fuzzyMatch(m.searchQuery, inst.ID) ||
-			fuzzyMatch(m.searchQuery, inst.IP) ||
-			fuzzyMatch(m.searchQuery, inst.Type) {
-			m.filtered = append(m.filtered, inst)
-		}
+	if err := favs.save(); err != nil {
+		m.warnings = append(m.warnings, "favourites: "+err.Error())
+		return
 	}
+	var selectedID string
+	if m.cursor < len(m.filtered) {
+		selectedID = m.filtered[m.cursor].ID
+	}
+	m.favourites = favs
+	m.filterInstances()
+	m.cursor = max(0, slices.IndexFunc(m.filtered, func(inst EC2Instance) bool { return inst.ID == selectedID }))
 }
 
-func (m model) View() string {
-	if m.mode == viewConfirm {
-		return m.renderMain() + "\n" + m.renderConfirm()
+// finishRefresh swaps in the refreshed inventory, marking what changed, and
+// keeps the cursor on the same instance
+func (m *model) finishRefresh() {
+	var selectedID string
+	if m.cursor < len(m.filtered) {
+		selectedID = m.filtered[m.cursor].ID
 	}
-	return m.renderMain()
+
+	m.instances = diffInventory(m.instances, m.incoming, m.uncached)
+	m.incoming = nil
+	m.uncached = nil
+	m.refreshing = false
+	m.filterInstances()
+
+	m.cursor = max(0, slices.IndexFunc(m.filtered, func(inst EC2Instance) bool { return inst.ID == selectedID }))
 }
 
-func (m model) renderMain() string {
-	var b strings.Builder
+// updateAction handles keys while an instance action is being confirmed.
+// Protected environments need the instance name typed and Enter; others
+// accept Y.
+func (m model) updateAction(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.Type == tea.KeyEsc || msg.Type == tea.KeyCtrlC {
+		m.mode = viewNormal
+		return m, nil
+	}
 
-	// Title bar
-	b.WriteString(m.titleBarStyle().Render(" relocate "))
-	b.WriteString("\n")
+	if !appConfig.Protected(m.actionInst.Environment) {
+		switch msg.String() {
+		case "y", "Y":
+			return m.runAction()
+		case "n", "N":
+			m.mode = viewNormal
+		}
+		return m, nil
+	}
 
-	// Header bar
-	var headerParts []string
-	headerParts = append(headerParts, fmt.Sprintf("Profile: %s", m.profile))
-	headerParts = append(headerParts, fmt.Sprintf("Region: %s", m.region))
-	headerParts = append(headerParts, fmt.Sprintf("Instances: %d", len(m.filtered)))
-	b.WriteString(m.headerStyle().Render(strings.Join(headerParts, "  •  ")))
-	b.WriteString("\n\n")
+	switch msg.Type {
+	case tea.KeyEnter:
+		if m.actionInput == instanceLabel(m.actionInst) {
+			return m.runAction()
+		}
+	case tea.KeyBackspace:
+		if len(m.actionInput) > 0 {
+			m.actionInput = m.actionInput[:len(m.actionInput)-1]
+		}
+	case tea.KeyRunes:
+		m.actionInput += msg.String()
+	}
+	return m, nil
+}
 
-	if m.loading {
-		return b.String() + m.renderLoading()
+// markAll marks every listed instance, or unmarks them if they are all
+// marked already
+func (m *model) markAll() {
+	all := len(m.filtered) > 0
+	for _, inst := range m.filtered {
+		all = all && m.marked[inst.ID]
+	}
+	if m.marked == nil {
+		m.marked = make(map[string]bool)
+	}
+	for _, inst := range m.filtered {
+		if all {
+			delete(m.marked, inst.ID)
+		} else {
+			m.marked[inst.ID] = true
+		}
 	}
+}
 
-	if m.err != "" {
-		return b.String() + m.renderError()
+// markedInstances returns the running instances a broadcast command runs
+// on or tmux opens: the marked ones, or else the one under the cursor
+func (m model) markedInstances() []EC2Instance {
+	var insts []EC2Instance
+	for _, inst := range m.instances {
+		if m.marked[inst.ID] && inst.State == "running" && inst.Change != changeRemoved {
+			insts = append(insts, inst)
+		}
+	}
+	if len(m.marked) == 0 && len(m.filtered) > 0 {
+		if inst := m.filtered[m.cursor]; inst.State == "running" && inst.Change != changeRemoved {
+			insts = append(insts, inst)
+		}
+	}
+	return insts
+}
+
+// updateBroadcast handles keys while a broadcast command is typed
+func (m model) updateBroadcast(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.mode = viewNormal
+	case tea.KeyEnter:
+		if strings.TrimSpace(m.commandInput) != "" {
+			return m.startBroadcast()
+		}
+	case tea.KeyBackspace:
+		if len(m.commandInput) > 0 {
+			m.commandInput = m.commandInput[:len(m.commandInput)-1]
+		}
+	case tea.KeyRunes, tea.KeySpace:
+		m.commandInput += msg.String()
+	}
+	return m, nil
+}
+
+// startBroadcast runs the typed command on the broadcast instances, each
+// over the transport and through the bastion it would be connected with.
+// Instances connected to over SSM run it over ssh-ssm, as an SSM shell
+// cannot run a command.
+func (m model) startBroadcast() (tea.Model, tea.Cmd) {
+	var targets []remoteTarget
+	var results []broadcastResult
+	for _, inst := range m.markedInstances() {
+		target := m.resolveTarget(inst)
+		if target.transport == config.TransportSSM {
+			target.transport = config.TransportSSHSSM
+		}
+		targets = append(targets, target)
+		results = append(results, broadcastResult{inst: inst, transport: target.transport, exitCode: -1})
+	}
+	if len(targets) == 0 {
+		m.mode = viewNormal
+		m.notice = "None of the marked instances is running"
+		m.noticeErr = true
+		return m, nil
+	}
+
+	// The timeout was validated at startup
+	timeout, _ := appConfig.BroadcastTimeout()
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := make(chan tea.Msg, 64)
+	go runBroadcast(ctx, targets, m.commandInput, appConfig.BroadcastEngine(), appConfig.BroadcastParallelism(), timeout, stream)
+
+	m.broadcast = broadcastRun{
+		command: m.commandInput,
+		started: time.Now(),
+		results: results,
+		running: true,
+		stream:  stream,
+		cancel:  cancel,
+	}
+	m.mode = viewResults
+	m.notice = ""
+	return m, tea.Batch(waitForBroadcast(stream), tick())
+}
+
+// resolveTarget resolves the connection to inst as the confirm dialog would
+// by default: its transport, address preference and bastion
+func (m model) resolveTarget(inst EC2Instance) remoteTarget {
+	target := remoteTarget{inst: inst, conn: m.connection(inst), transport: m.defaultTransport(inst)}
+	if config.DirectTransport(target.transport) {
+		if jump, ok := selectBastion(appConfig.Bastions, m.instances, inst, target.conn.User); ok {
+			target.conn.ProxyJump = jump.spec
+		}
+	}
+	return target
+}
+
+// updateTmux handles keys in the dialog that opens instances in tmux
+func (m model) updateTmux(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if msg.Type == tea.KeyEsc || msg.Type == tea.KeyCtrlC {
+		m.mode = viewNormal
+		return m, nil
+	}
+	switch msg.String() {
+	case "y", "Y", "enter":
+		var targets []remoteTarget
+		for _, inst := range m.markedInstances() {
+			targets = append(targets, m.resolveTarget(inst))
+		}
+		m.mode = viewNormal
+		m.notice = fmt.Sprintf("Opening %d instances in tmux…", len(targets))
+		m.noticeErr = false
+		return m, openTmux(targets, m.tmuxLayout, m.tmuxSync, m.width, m.height)
+	case "n", "N":
+		m.mode = viewNormal
+	case "l", "L":
+		i := slices.Index(config.TmuxLayouts, m.tmuxLayout)
+		m.tmuxLayout = config.TmuxLayouts[(i+1)%len(config.TmuxLayouts)]
+	case "s", "S":
+		m.tmuxSync = !m.tmuxSync
+	}
+	return m, nil
+}
+
+// updateForward handles keys in the dialog that picks the forward of a new
+// tunnel
+func (m model) updateForward(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	forwards := appConfig.Forwards
+	switch msg.Type {
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.mode = viewNormal
+	case tea.KeyUp:
+		m.forwardIdx = max(0, m.forwardIdx-1)
+	case tea.KeyDown:
+		m.forwardIdx = min(len(forwards)-1, m.forwardIdx+1)
+	case tea.KeyEnter:
+		forward := forwards[m.forwardIdx]
+		m.mode = viewNormal
+		m.notice = fmt.Sprintf("Opening tunnel %s through %s…", forward, instanceLabel(m.tunnelInst))
+		m.noticeErr = false
+		return m, openTunnel(m.resolveTarget(m.tunnelInst), forward)
+	case tea.KeyRunes:
+		switch msg.String() {
+		case "k":
+			m.forwardIdx = max(0, m.forwardIdx-1)
+		case "j":
+			m.forwardIdx = min(len(forwards)-1, m.forwardIdx+1)
+		}
+	}
+	return m, nil
+}
+
+// updateTransfer handles keys in the file transfer dialog
+func (m model) updateTransfer(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	if m.transfer.running {
+		switch msg.Type {
+		case tea.KeyEsc:
+			m.transfer.cancel()
+		case tea.KeyCtrlC:
+			m.transfer.cancel()
+			return m, tea.Quit
+		}
+		return m, nil
+	}
+
+	path := &m.transfer.local
+	if m.transfer.field == 2 {
+		path = &m.transfer.remote
+	}
+	switch msg.Type {
+	case tea.KeyEsc, tea.KeyCtrlC:
+		m.mode = viewNormal
+	case tea.KeyUp, tea.KeyShiftTab:
+		m.transfer.field = (m.transfer.field + 2) % 3
+	case tea.KeyDown, tea.KeyTab:
+		m.transfer.field = (m.transfer.field + 1) % 3
+	case tea.KeyEnter:
+		if m.transfer.local == "" {
+			m.transfer.field = 1
+			return m, nil
+		}
+		if m.transfer.remote == "" && !m.transfer.upload {
+			// Downloading the whole home directory is never meant
+			m.transfer.field = 2
+			return m, nil
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		m.transfer.progress = &transferProgress{}
+		m.transfer.cancel = cancel
+		m.transfer.started = time.Now()
+		m.transfer.running = true
+		return m, tea.Batch(startTransfer(ctx, m.resolveTarget(m.transfer.inst), m.transfer), tick())
+	case tea.KeyLeft, tea.KeyRight:
+		if m.transfer.field == 0 {
+			m.transfer.upload = !m.transfer.upload
+		}
+	case tea.KeyBackspace:
+		if m.transfer.field > 0 && len(*path) > 0 {
+			*path = (*path)[:len(*path)-1]
+		}
+	case tea.KeySpace:
+		if m.transfer.field == 0 {
+			m.transfer.upload = !m.transfer.upload
+		} else {
+			*path += " "
+		}
+	case tea.KeyRunes:
+		if m.transfer.field > 0 {
+			*path += msg.String()
+		}
+	}
+	return m, nil
+}
+
+// updateTunnels handles keys in the tunnels view
+func (m model) updateTunnels(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		return m, tea.Quit
+	case tea.KeyEsc:
+		m.mode = viewNormal
+	case tea.KeyUp:
+		m.tunnelIdx = max(0, m.tunnelIdx-1)
+	case tea.KeyDown:
+		m.tunnelIdx = max(0, min(len(m.tunnels)-1, m.tunnelIdx+1))
+	case tea.KeyRunes:
+		switch msg.String() {
+		case "k":
+			m.tunnelIdx = max(0, m.tunnelIdx-1)
+		case "j":
+			m.tunnelIdx = max(0, min(len(m.tunnels)-1, m.tunnelIdx+1))
+		case "x", "X", "d", "D":
+			// Close the selected tunnel, or dismiss it if it closed by itself
+			if len(m.tunnels) == 0 {
+				return m, nil
+			}
+			t := m.tunnels[m.tunnelIdx]
+			t.Close()
+			m.tunnels = slices.Delete(m.tunnels, m.tunnelIdx, m.tunnelIdx+1)
+			m.tunnelIdx = max(0, min(m.tunnelIdx, len(m.tunnels)-1))
+			m.notice = fmt.Sprintf("Closed tunnel %s through %s", t.forward, instanceLabel(t.inst))
+			m.noticeErr = false
+		}
+	}
+	return m, nil
+}
+
+// updateResults handles keys in the broadcast results view
+func (m model) updateResults(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.Type {
+	case tea.KeyCtrlC:
+		m.broadcast.cancel()
+		return m, tea.Quit
+	case tea.KeyEsc:
+		if m.broadcast.running {
+			// Stop what is still running, keep the results so far
+			m.broadcast.cancel()
+			m.broadcast.running = false
+			for i := range m.broadcast.results {
+				if result := &m.broadcast.results[i]; !result.done {
+					result.err = fmt.Errorf("stopped")
+				}
+			}
+			return m, nil
+		}
+		m.mode = viewNormal
+	case tea.KeyUp:
+		m.broadcast.cursor = max(0, m.broadcast.cursor-1)
+	case tea.KeyDown:
+		m.broadcast.cursor = min(len(m.broadcast.results)-1, m.broadcast.cursor+1)
+	case tea.KeyRunes:
+		switch msg.String() {
+		case "k":
+			m.broadcast.cursor = max(0, m.broadcast.cursor-1)
+		case "j":
+			m.broadcast.cursor = min(len(m.broadcast.results)-1, m.broadcast.cursor+1)
+		case "e", "E":
+			name, err := m.broadcast.export()
+			if err != nil {
+				m.notice = fmt.Sprintf("Failed to export results: %v", err)
+				m.noticeErr = true
+			} else {
+				m.notice = "Exported results to " + name
+				m.noticeErr = false
+			}
+		}
+	}
+	return m, nil
+}
+
+// runAction closes the action dialog and sends the confirmed action
+func (m model) runAction() (tea.Model, tea.Cmd) {
+	if m.connectAfter {
+		return m.startAndConnect()
+	}
+	m.mode = viewNormal
+	m.notice = fmt.Sprintf("%s %s…", actionProgress(m.action), instanceLabel(m.actionInst))
+	m.noticeErr = false
+	return m, runInstanceAction(m.actionInst, m.action)
+}
+
+// startAndConnect starts the stopped instance of the action dialog and
+// shows the progress until it can be connected to
+func (m model) startAndConnect() (tea.Model, tea.Cmd) {
+	ctx, cancel := context.WithTimeout(context.Background(), startConnectTimeout)
+	m.mode = viewStarting
+	m.startCancel = cancel
+	m.startSteps = nil
+	m.startErr = ""
+
+	// Buffered for every step and the result, so the flow never blocks
+	// once the dialog is closed
+	stream := make(chan tea.Msg, 8)
+	m.startStream = stream
+	go startInstance(ctx, m.actionInst, m.probeAddress, stream)
+	return m, tea.Batch(waitForStart(stream), tick())
+}
+
+// probeAddress returns the host:port that must accept connections before
+// a started instance can be connected to, or "" when there is nothing to
+// probe (SSM transports, or a connection through a bastion)
+func (m model) probeAddress(inst EC2Instance) string {
+	conn := m.connection(inst)
+	if !config.DirectTransport(m.defaultTransport(inst)) {
+		return ""
+	}
+	if _, ok := selectBastion(appConfig.Bastions, m.instances, inst, conn.User); ok {
+		return ""
+	}
+	kind, ok := preferredAddress(inst, conn.Addresses)
+	if !ok {
+		return ""
+	}
+	return net.JoinHostPort(inst.Address(kind), strconv.Itoa(conn.Port))
+}
+
+// selectTarget makes inst the instance to connect to, with its default
+// transport and address
+func (m *model) selectTarget(inst EC2Instance) {
+	m.target = inst
+	m.transport = m.defaultTransport(inst)
+	conn := m.connection(inst)
+	m.address, _ = preferredAddress(inst, conn.Addresses)
+}
+
+// updateInstance replaces the inventory entry with the same ID as inst
+func (m *model) updateInstance(inst EC2Instance) {
+	i := slices.IndexFunc(m.instances, func(e EC2Instance) bool { return e.ID == inst.ID })
+	if i < 0 {
+		return
+	}
+	m.instances[i] = inst
+	m.filterInstances()
+	if m.cursor >= len(m.filtered) {
+		m.cursor = max(0, len(m.filtered)-1)
+	}
+}
+
+// instanceLabel is the instance name, or its ID if it has none
+func instanceLabel(inst EC2Instance) string {
+	if inst.Name == "" {
+		return inst.ID
+	}
+	return inst.Name
+}
+
+// multiProfile reports whether instances from more than one profile are listed
+func (m model) multiProfile() bool {
+	return len(m.profiles) > 1 && m.profileIdx == 0
+}
+
+// multiRegion reports whether instances from more than one region are listed
+func (m model) multiRegion() bool {
+	return len(m.regions) > 1 || slices.Contains(m.regions, allRegions)
+}
+
+// defaultTransport returns the transport an instance is connected with
+// unless it is toggled in the confirm dialog
+func (m model) defaultTransport(inst EC2Instance) string {
+	return appConfig.TransportFor(m.connection(inst), m.overrides, inst.Tags)
+}
+
+// connection resolves the connection settings of an instance's environment
+// (CLI flag > environment > defaults)
+func (m model) connection(inst EC2Instance) config.Connection {
+	return appConfig.ResolveConnection(inst.Environment, m.overrides)
+}
+
+// bastion returns the bastion an ssh connection to inst jumps through.
+// Bastions are only used by the ssh and native transports; SSM needs no
+// open path.
+func (m model) bastion(inst EC2Instance, conn config.Connection) (jumpPath, bool) {
+	if !config.DirectTransport(m.transport) {
+		return jumpPath{}, false
+	}
+	return selectBastion(appConfig.Bastions, m.instances, inst, conn.User)
+}
+
+// envModes lists the selectable environments in tab order, ending with
+// the unclassified bucket
+func envModes() []string {
+	var modes []string
+	for _, env := range appConfig.EnvironmentList() {
+		modes = append(modes, env.Name)
+	}
+	return append(modes, config.Unclassified)
+}
+
+// envHotkey maps the number keys 1-9 to the declared environments and 0 to
+// the unclassified bucket
+func envHotkey(key string) (string, bool) {
+	if key == "0" {
+		return config.Unclassified, true
+	}
+	envs := appConfig.EnvironmentList()
+	n := int(key[0] - '0')
+	if n > len(envs) {
+		return "", false
+	}
+	return envs[n-1].Name, true
+}
+
+func (m *model) filterInstances() {
+	// First filter by environment, or by history in the recent view
+	var envFiltered []EC2Instance
+	for _, inst := range m.instances {
+		if m.profileIdx > 0 && inst.Profile != m.profiles[m.profileIdx-1] {
+			continue
+		}
+		if !m.allStates && inst.State != "running" {
+			continue
+		}
+
+		if m.matches != nil {
+			if m.matches[inst.ID] {
+				envFiltered = append(envFiltered, inst)
+			}
+			continue
+		}
+		if m.recent {
+			if m.frecency[inst.ID] > 0 {
+				envFiltered = append(envFiltered, inst)
+			}
+			continue
+		}
+
+		// Environment is assigned by the classification rules during discovery
+		if inst.Environment == m.envMode {
+			envFiltered = append(envFiltered, inst)
+		}
+	}
+
+	// Then apply search query if present
+	if m.searchQuery == "" {
+		m.filtered = envFiltered
+	} else {
+		m.filtered = nil
+		for _, inst := range envFiltered {
+			if searchMatch(m.searchQuery, inst) {
+				m.filtered = append(m.filtered, inst)
+			}
+		}
+	}
+
+	// Starred instances are pinned to the top, then frequently and recently
+	// used instances come first
+	byFrecency := m.recent || m.searchQuery != ""
+	slices.SortStableFunc(m.filtered, func(a, b EC2Instance) int {
+		if r := cmp.Compare(boolRank(m.favourites.contains(b)), boolRank(m.favourites.contains(a))); r != 0 || !byFrecency {
+			return r
+		}
+		return cmp.Compare(m.frecency[b.ID], m.frecency[a.ID])
+	})
+}
+
+// boolRank orders true after false
+func boolRank(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func (m model) View() string {
+	if m.mode == viewResults {
+		return m.renderResults()
+	}
+	if m.mode == viewCommand {
+		return m.renderMain() + "\n" + m.renderBroadcast()
+	}
+	if m.mode == viewTmux {
+		return m.renderMain() + "\n" + m.renderTmux()
+	}
+	if m.mode == viewForward {
+		return m.renderMain() + "\n" + m.renderForward()
+	}
+	if m.mode == viewTunnels {
+		return m.renderTunnels()
+	}
+	if m.mode == viewTransfer {
+		return m.renderMain() + "\n" + m.renderTransfer()
+	}
+	if m.mode == viewConfirm {
+		return m.renderMain() + "\n" + m.renderConfirm()
+	}
+	if m.mode == viewAction {
+		return m.renderMain() + "\n" + m.renderAction()
+	}
+	if m.mode == viewStarting {
+		return m.renderMain() + "\n" + m.renderStarting()
+	}
+	return m.renderMain()
+}
+
+func (m model) renderMain() string {
+	var b strings.Builder
+
+	// Title bar
+	b.WriteString(m.titleBarStyle().Render(" relocate "))
+	b.WriteString("\n")
+
+	// Header bar
+	var headerParts []string
+	headerParts = append(headerParts, m.renderProfiles())
+	headerParts = append(headerParts, m.renderRegions())
+	headerParts = append(headerParts, fmt.Sprintf("Instances: %d", len(m.filtered)))
+	if len(m.marked) > 0 {
+		headerParts = append(headerParts, fmt.Sprintf("Marked: %d", len(m.marked)))
+	}
+	if len(m.tunnels) > 0 {
+		headerParts = append(headerParts, fmt.Sprintf("Tunnels: %d", len(m.tunnels)))
+	}
+	if m.loading && len(m.instances) > 0 {
+		spinner := spinnerFrames[m.spinnerIdx]
+		headerParts = append(headerParts, fmt.Sprintf("%s %d loaded, fetching more…", spinner, len(m.instances)))
+	}
+	if m.refreshing {
+		headerParts = append(headerParts, fmt.Sprintf("%s refreshing…", spinnerFrames[m.spinnerIdx]))
+	} else if !m.loading {
+		headerParts = append(headerParts, "As of "+asOfLabel(m.lastUpdate))
+	}
+	if m.offline {
+		headerParts = append(headerParts, "Offline")
+	}
+	b.WriteString(m.headerStyle().Render(strings.Join(headerParts, "  •  ")))
+	b.WriteString("\n")
+
+	// Warnings (e.g. regions that failed to load)
+	for _, warning := range m.warnings {
+		b.WriteString(m.headerStyle().Foreground(warningColor).Render("! " + warning))
+		b.WriteString("\n")
+	}
+	if m.notice != "" {
+		noticeColor := primaryColor
+		if m.noticeErr {
+			noticeColor = errorColor
+		}
+		b.WriteString(m.headerStyle().Foreground(noticeColor).Render(m.notice))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	if m.loading && len(m.instances) == 0 {
+		return b.String() + m.renderLoading()
+	}
+
+	if m.err != "" {
+		return b.String() + m.renderError()
 	}
 
 	// Main content: list + details side by side
@@ -465,8 +1745,60 @@ func (m model) renderMain() string {
 	return b.String()
 }
 
+// asOfLabel formats when the inventory was loaded, with the date if it was
+// not today
+func asOfLabel(t time.Time) string {
+	if y, m, d := t.Date(); time.Now().Year() != y || time.Now().Month() != m || time.Now().Day() != d {
+		return t.Format("Jan 2 15:04")
+	}
+	return t.Format("15:04:05")
+}
+
+// renderProfiles shows the profile being browsed, or how many are loaded
+func (m model) renderProfiles() string {
+	switch {
+	case len(m.profiles) == 1:
+		return fmt.Sprintf("Profile: %s", m.profiles[0])
+	case m.profileIdx == 0:
+		return fmt.Sprintf("Profiles: all (%d)", len(m.profiles))
+	default:
+		return fmt.Sprintf("Profile: %s (%d/%d)", m.profiles[m.profileIdx-1], m.profileIdx, len(m.profiles))
+	}
+}
+
+// renderRegions shows the queried region, or per-region instance counts when
+// several regions are loaded
+func (m model) renderRegions() string {
+	var regions []string
+	for _, region := range m.regions {
+		if region != allRegions {
+			regions = append(regions, region)
+		}
+	}
+	counts := make(map[string]int)
+	for _, inst := range m.filtered {
+		if counts[inst.Region] == 0 && !slices.Contains(regions, inst.Region) {
+			regions = append(regions, inst.Region)
+		}
+		counts[inst.Region]++
+	}
+
+	if len(regions) == 0 {
+		return fmt.Sprintf("Region: %s", strings.Join(m.regions, ","))
+	}
+	if len(regions) == 1 {
+		return fmt.Sprintf("Region: %s", regions[0])
+	}
+
+	var parts []string
+	for _, region := range regions {
+		parts = append(parts, fmt.Sprintf("%s (%d)", region, counts[region]))
+	}
+	return "Regions: " + strings.Join(parts, " ")
+}
+
 func (m model) renderLoading() string {
-	spinner := []string{"◜", "◠", "◝", "◞"}[m.spinnerIdx]
+	spinner := spinnerFrames[m.spinnerIdx]
 	loadingStyle := lipgloss.NewStyle().
 		Foreground(primaryColor).
 		Margin(1, 2)
@@ -485,7 +1817,14 @@ func (m model) renderList() string {
 	var items []string
 
 	// Header
-	items = append(items, sectionHeaderStyle.Render("Instances"))
+	header := "Instances"
+	switch {
+	case m.matches != nil:
+		header = fmt.Sprintf("Matching %q (Esc for all)", m.matchQuery)
+	case m.recent:
+		header = "Recent"
+	}
+	items = append(items, sectionHeaderStyle.Render(header))
 
 	// Calculate visible items based on container height
 	visibleItems := m.height - 12
@@ -506,8 +1845,11 @@ func (m model) renderList() string {
 	for i := start; i < end; i++ {
 		inst := m.filtered[i]
 		stateIcon := runningDot
-		if inst.State != "running" {
+		switch inst.State {
+		case "stopped":
 			stateIcon = stoppedDot
+		case "pending", "stopping":
+			stateIcon = pendingDot
 		}
 
 		name := inst.Name
@@ -517,6 +1859,27 @@ func (m model) renderList() string {
 
 		// Truncate name based on available width
 		maxNameLen := (m.width / 2) - 8
+		var labels []string
+		if m.multiProfile() {
+			labels = append(labels, inst.Account)
+		}
+		if m.multiRegion() {
+			labels = append(labels, inst.Region)
+		}
+		label := strings.Join(labels, " ")
+		if label != "" {
+			maxNameLen -= len(label) + 1
+		}
+		if inst.Change != "" {
+			maxNameLen -= 2
+		}
+		starred := m.favourites.contains(inst)
+		if starred {
+			maxNameLen -= 2
+		}
+		if m.marked[inst.ID] {
+			maxNameLen -= 2
+		}
 		if maxNameLen < 15 {
 			maxNameLen = 15
 		}
@@ -524,7 +1887,19 @@ func (m model) renderList() string {
 			name = name[:maxNameLen-3] + "..."
 		}
 
+		if starred {
+			name = favouriteMarker + " " + name
+		}
+		if m.marked[inst.ID] {
+			name = markedMarker + " " + name
+		}
 		item := fmt.Sprintf("%s %s", stateIcon, name)
+		if marker, ok := changeMarkers[inst.Change]; ok {
+			item = fmt.Sprintf("%s %s %s", stateIcon, marker, name)
+		}
+		if label != "" {
+			item += " " + lipgloss.NewStyle().Foreground(faintColor).Render(label)
+		}
 
 		if i == m.cursor {
 			items = append(items, m.selectedItemStyle().Render(item))
@@ -549,6 +1924,11 @@ func (m model) renderDetails() string {
 
 	inst := m.filtered[m.cursor]
 
+	account := inst.AccountID
+	if inst.Account != inst.AccountID {
+		account = fmt.Sprintf("%s (%s)", inst.Account, inst.AccountID)
+	}
+
 	details := []string{
 		sectionHeaderStyle.Render("Details"),
 		"",
@@ -558,29 +1938,62 @@ func (m model) renderDetails() string {
 		"",
 		detailLabelStyle.Render("AMI") + " " + detailValueStyle.Render(inst.AMI),
 		"",
-		detailLabelStyle.Render("IP") + " " + detailValueStyle.Render(inst.IP),
+		detailLabelStyle.Render("Public IP") + " " + detailValueStyle.Render(inst.PublicIP),
+		detailLabelStyle.Render("Private IP") + " " + detailValueStyle.Render(inst.PrivateIP),
+		detailLabelStyle.Render("IPv6") + " " + detailValueStyle.Render(inst.IPv6),
+		detailLabelStyle.Render("Public DNS") + " " + detailValueStyle.Render(inst.PublicDNS),
+		detailLabelStyle.Render("Private DNS") + " " + detailValueStyle.Render(inst.PrivateDNS),
 		"",
 		detailLabelStyle.Render("Type") + " " + detailValueStyle.Render(inst.Type),
 		"",
+		detailLabelStyle.Render("Account") + " " + detailValueStyle.Render(account),
+		"",
+		detailLabelStyle.Render("Profile") + " " + detailValueStyle.Render(inst.Profile),
+		"",
+		detailLabelStyle.Render("Region") + " " + detailValueStyle.Render(inst.Region),
+		"",
 		detailLabelStyle.Render("Zone") + " " + detailValueStyle.Render(inst.Zone),
 		"",
 		detailLabelStyle.Render("State") + " " + detailValueStyle.Render(inst.State),
 		"",
 		detailLabelStyle.Render("Key") + " " + detailValueStyle.Render(inst.KeyName),
+		"",
+		detailLabelStyle.Render("VPC") + " " + detailValueStyle.Render(inst.VpcID),
+		"",
+		detailLabelStyle.Render("Env") + " " + detailValueStyle.Render(inst.Environment),
+		"",
+		detailLabelStyle.Render("SSM") + " " + renderSSMStatus(inst.SSMStatus),
 	}
 
 	return m.detailContainerStyle().Render(lipgloss.JoinVertical(lipgloss.Left, details...))
 }
 
+// ssmNotManaged is the SSM status of instances not registered with SSM
+const ssmNotManaged = "Not managed"
+
+// renderSSMStatus colours the SSM agent status for the details pane
+func renderSSMStatus(status string) string {
+	switch status {
+	case "":
+		return detailValueStyle.Foreground(dimColor).Render("unknown")
+	case "Online":
+		return detailValueStyle.Foreground(successColor).Render(status)
+	case ssmNotManaged:
+		return detailValueStyle.Foreground(dimColor).Render(status)
+	default:
+		return detailValueStyle.Foreground(warningColor).Render(status)
+	}
+}
+
 func (m model) renderKeySelector() string {
-	stagingStyle := lipgloss.NewStyle().
+	inactiveStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#111827")).
 		Background(lipgloss.Color("#9CA3AF")).
 		Padding(0, 2)
 
-	prodStyle := lipgloss.NewStyle().
+	unclassifiedStyle := lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#111827")).
-		Background(lipgloss.Color("#9CA3AF")).
+		Background(dimColor).
 		Padding(0, 2)
 
 	activeStyle := lipgloss.NewStyle().
@@ -589,157 +2002,516 @@ func (m model) renderKeySelector() string {
 		Bold(true).
 		Padding(0, 2)
 
-	var stagingBtn, prodBtn string
-	if m.envMode == "staging" {
-		stagingBtn = activeStyle.Render(" [1] Staging ")
-		prodBtn = prodStyle.Render(" [2] Prod ")
+	var buttons []string
+	for i, env := range appConfig.EnvironmentList() {
+		label := " " + envTitle(env.Name) + " "
+		if i < 9 {
+			label = fmt.Sprintf(" [%d] %s ", i+1, envTitle(env.Name))
+		}
+
+		if env.Name != m.envMode || m.recent || m.matches != nil {
+			buttons = append(buttons, inactiveStyle.Render(label))
+			continue
+		}
+		style := activeStyle
+		if env.Color != "" {
+			style = style.Background(lipgloss.Color(env.Color))
+		}
+		buttons = append(buttons, style.Render(label))
+	}
+
+	if m.envMode == config.Unclassified && !m.recent && m.matches == nil {
+		buttons = append(buttons, activeStyle.Render(" [0] Unclassified "))
 	} else {
-		stagingBtn = stagingStyle.Render(" [1] Staging ")
-		prodBtn = activeStyle.Render(" [2] Prod ")
+		buttons = append(buttons, unclassifiedStyle.Render(" [0] Unclassified "))
+	}
+
+	if m.recent {
+		buttons = append(buttons, activeStyle.Render(" [^E] Recent "))
+	} else {
+		buttons = append(buttons, inactiveStyle.Render(" [^E] Recent "))
 	}
 
 	return m.keySelectorStyle().Render(
-		lipgloss.JoinHorizontal(lipgloss.Left, stagingBtn, prodBtn),
+		lipgloss.JoinHorizontal(lipgloss.Left, buttons...),
 	)
 }
 
-func (m model) renderConfirm() string {
-	if len(m.filtered) == 0 {
-		return ""
+// envTitle capitalizes an environment name for display
+func envTitle(name string) string {
+	if name == "" {
+		return name
 	}
+	return strings.ToUpper(name[:1]) + name[1:]
+}
 
-	inst := m.filtered[m.cursor]
-	keyName, err := appConfig.GetSSHKey(m.envMode)
-	if err != nil {
-		keyName = "(not configured)"
+func (m model) renderConfirm() string {
+	inst := m.target
+	conn := m.connection(inst)
+	keyPath := conn.KeyPath
+	if keyPath == "" {
+		keyPath = "(not configured)"
 	}
 
 	content := lipgloss.JoinVertical(lipgloss.Center,
 		lipgloss.NewStyle().Bold(true).Foreground(accentColor).Render("Connect to instance?"),
 		"",
 		detailLabelStyle.Render("Name")+detailValueStyle.Render(inst.Name),
-		detailLabelStyle.Render("IP")+detailValueStyle.Render(inst.IP),
-		detailLabelStyle.Render("Key")+detailValueStyle.Render(keyName),
+		detailLabelStyle.Render("Via")+detailValueStyle.Render(m.transport),
+	)
+	keys := "[Y] Yes  [N] No  [T] Transport  [ESC] Cancel"
+	if config.DirectTransport(m.transport) {
+		address := "(none)"
+		if m.address != "" {
+			address = fmt.Sprintf("%s (%s)", inst.Address(m.address), m.address)
+		}
+		content = lipgloss.JoinVertical(lipgloss.Center,
+			content,
+			detailLabelStyle.Render("Address")+detailValueStyle.Render(address),
+		)
+		keys = "[Y] Yes  [N] No  [T] Transport  [A] Address  [ESC] Cancel"
+	} else {
+		content = lipgloss.JoinVertical(lipgloss.Center,
+			content,
+			detailLabelStyle.Render("ID")+detailValueStyle.Render(inst.ID),
+		)
+	}
+	if m.transport != config.TransportSSM {
+		auth := detailLabelStyle.Render("Key") + detailValueStyle.Render(keyPath)
+		if conn.Auth == config.AuthInstanceConnect {
+			auth = detailLabelStyle.Render("Auth") + detailValueStyle.Render("EC2 Instance Connect (ephemeral key)")
+		}
+		path := "direct"
+		if jump, ok := m.bastion(inst, conn); ok {
+			path = fmt.Sprintf("%s (%s)", jump.name, jump.spec)
+		}
+		content = lipgloss.JoinVertical(lipgloss.Center,
+			content,
+			detailLabelStyle.Render("User")+detailValueStyle.Render(fmt.Sprintf("%s (port %d)", conn.User, conn.Port)),
+			auth,
+			detailLabelStyle.Render("Path")+detailValueStyle.Render(path),
+		)
+	}
+	content = lipgloss.JoinVertical(lipgloss.Center,
+		content,
 		"",
-		lipgloss.NewStyle().Foreground(dimColor).Render("[Y] Yes  [N] No  [ESC] Cancel"),
+		lipgloss.NewStyle().Foreground(dimColor).Render(keys),
 	)
 
 	return m.confirmStyle().Render(content)
 }
 
-func (m model) renderStatusBar() string {
-	var parts []string
-
-	if m.searchQuery != "" {
-		parts = append(parts, fmt.Sprintf("Search: %s", m.searchQuery))
+// renderAction is the confirmation dialog of a start, stop or reboot
+func (m model) renderAction() string {
+	inst := m.actionInst
+	title := envTitle(m.action) + " instance?"
+	if m.connectAfter {
+		title = "Instance is stopped. Start and connect?"
+	}
+	lines := []string{
+		lipgloss.NewStyle().Bold(true).Foreground(accentColor).Render(title),
+		"",
+		detailLabelStyle.Render("Name") + detailValueStyle.Render(inst.Name),
+		detailLabelStyle.Render("ID") + detailValueStyle.Render(inst.ID),
+		detailLabelStyle.Render("State") + detailValueStyle.Render(inst.State),
+		detailLabelStyle.Render("Env") + detailValueStyle.Render(inst.Environment),
+		"",
 	}
 
-	parts = append(parts, "↑↓ navigate")
-	parts = append(parts, "Enter connect")
-	parts = append(parts, "[1/2] env")
-	parts = append(parts, "type search")
-	parts = append(parts, "Ctrl+C quit")
+	if appConfig.Protected(inst.Environment) {
+		lines = append(lines,
+			fmt.Sprintf("Type %s to confirm:", detailValueStyle.Render(instanceLabel(inst))),
+			detailValueStyle.Render(m.actionInput+"_"),
+			"",
+			lipgloss.NewStyle().Foreground(dimColor).Render("[Enter] Confirm  [ESC] Cancel"),
+		)
+	} else {
+		lines = append(lines, lipgloss.NewStyle().Foreground(dimColor).Render("[Y] Yes  [N] No  [ESC] Cancel"))
+	}
 
-	return strings.Join(parts, "  •  ")
+	return m.confirmStyle().Render(lipgloss.JoinVertical(lipgloss.Center, lines...))
 }
 
-func loadInstances(profile, region, filterTag string) tea.Cmd {
-	return func() tea.Msg {
-		cfg, err := awsconfig.LoadDefaultConfig(context.TODO(),
-			awsconfig.WithSharedConfigProfile(profile),
-			awsconfig.WithRegion(region),
-		)
-		if err != nil {
-			return errorMsg{err: "Failed to load AWS config"}
+// renderBroadcast is the prompt for a command to run on several instances
+func (m model) renderBroadcast() string {
+	insts := m.markedInstances()
+	var names []string
+	for i, inst := range insts {
+		if i == 5 {
+			names = append(names, fmt.Sprintf("and %d more", len(insts)-i))
+			break
 		}
+		names = append(names, instanceLabel(inst))
+	}
 
-		client := ec2.NewFromConfig(cfg)
-
-		filters := []types.Filter{
-			{
-				Name:   aws.String("instance-state-name"),
-				Values: []string{"running"},
-			},
+	lines := []string{
+		lipgloss.NewStyle().Bold(true).Foreground(accentColor).Render(fmt.Sprintf("Run a command on %d instances", len(insts))),
+		"",
+		detailValueStyle.Render(strings.Join(names, ", ")),
+	}
+	var protected []string
+	for _, inst := range insts {
+		if appConfig.Protected(inst.Environment) && !slices.Contains(protected, inst.Environment) {
+			protected = append(protected, inst.Environment)
 		}
+	}
+	if len(protected) > 0 {
+		lines = append(lines, lipgloss.NewStyle().Foreground(errorColor).Render("Includes protected environments: "+strings.Join(protected, ", ")))
+	}
+	lines = append(lines,
+		"",
+		detailValueStyle.Render("$ "+m.commandInput+"_"),
+		"",
+		lipgloss.NewStyle().Foreground(dimColor).Render("[Enter] Run  [ESC] Cancel"),
+	)
 
-		if filterTag != "" {
-			parts := strings.SplitN(filterTag, "=", 2)
-			if len(parts) == 2 {
-				filters = append(filters, types.Filter{
-					Name:   aws.String("tag:" + parts[0]),
-					Values: []string{parts[1]},
-				})
-			}
+	return m.confirmStyle().Render(lipgloss.JoinVertical(lipgloss.Center, lines...))
+}
+
+// renderTmux is the dialog that opens instances in tmux
+func (m model) renderTmux() string {
+	insts := m.markedInstances()
+	where := "a new tmux session"
+	if insideTmux() {
+		where = "a new window of this tmux session"
+	}
+	layout := "one pane per instance"
+	if m.tmuxLayout == config.TmuxWindows {
+		layout = "one window per instance"
+	}
+	sync := "off"
+	if m.tmuxSync {
+		sync = "on"
+	}
+
+	lines := []string{
+		lipgloss.NewStyle().Bold(true).Foreground(accentColor).Render(fmt.Sprintf("Open %d instances in tmux?", len(insts))),
+		"",
+		detailLabelStyle.Render("Where") + detailValueStyle.Render(where),
+		detailLabelStyle.Render("Layout") + detailValueStyle.Render(fmt.Sprintf("%s (%s)", m.tmuxLayout, layout)),
+	}
+	keys := "[Y] Yes  [N] No  [L] Layout  [ESC] Cancel"
+	if m.tmuxLayout == config.TmuxTiled {
+		lines = append(lines, detailLabelStyle.Render("Sync")+detailValueStyle.Render(sync))
+		keys = "[Y] Yes  [N] No  [L] Layout  [S] Sync  [ESC] Cancel"
+	}
+	lines = append(lines, "", lipgloss.NewStyle().Foreground(dimColor).Render(keys))
+
+	return m.confirmStyle().Render(lipgloss.JoinVertical(lipgloss.Center, lines...))
+}
+
+// renderForward is the dialog that picks the forward of a new tunnel
+func (m model) renderForward() string {
+	lines := []string{
+		lipgloss.NewStyle().Bold(true).Foreground(accentColor).Render("Open a tunnel through " + instanceLabel(m.tunnelInst)),
+		"",
+	}
+	for i, forward := range appConfig.Forwards {
+		item := fmt.Sprintf("%s  %s", forward.Name, lipgloss.NewStyle().Foreground(faintColor).Render(forward.String()))
+		if i == m.forwardIdx {
+			item = lipgloss.NewStyle().Bold(true).Render("> " + item)
+		} else {
+			item = "  " + item
 		}
+		lines = append(lines, item)
+	}
+	lines = append(lines, "", lipgloss.NewStyle().Foreground(dimColor).Render("[↑↓] Forward  [Enter] Open  [ESC] Cancel"))
 
-		resp, err := client.DescribeInstances(context.TODO(), &ec2.DescribeInstancesInput{
-			Filters: filters,
-		})
-		if err != nil {
-			return errorMsg{err: fmt.Sprintf("AWS error: %v", err)}
+	return m.confirmStyle().Render(lipgloss.JoinVertical(lipgloss.Center, lines...))
+}
+
+// renderTransfer renders the file transfer dialog, with the progress of the
+// transfer once it runs
+func (m model) renderTransfer() string {
+	run := m.transfer
+	title := "Upload to " + instanceLabel(run.inst)
+	if !run.upload {
+		title = "Download from " + instanceLabel(run.inst)
+	}
+	lines := []string{
+		lipgloss.NewStyle().Bold(true).Foreground(accentColor).Render(title),
+		"",
+	}
+
+	field := func(i int, label, value string) string {
+		if i == run.field && !run.running {
+			return lipgloss.NewStyle().Bold(true).Render(fmt.Sprintf("> %-10s %s", label, value))
+		}
+		return fmt.Sprintf("  %-10s %s", label, value)
+	}
+	direction := "Upload"
+	if !run.upload {
+		direction = "Download"
+	}
+	local, remote := run.local, run.remote
+	if !run.running {
+		switch run.field {
+		case 1:
+			local += "_"
+		case 2:
+			remote += "_"
 		}
+	}
+	switch {
+	case remote != "":
+	case run.upload:
+		remote = lipgloss.NewStyle().Foreground(faintColor).Render("home directory")
+	default:
+		remote = lipgloss.NewStyle().Foreground(faintColor).Render("required")
+	}
+	lines = append(lines,
+		field(0, "Direction", direction),
+		field(1, "Local", local),
+		field(2, "Remote", remote),
+		"",
+	)
 
-		var instances []EC2Instance
-		for _, res := range resp.Reservations {
-			for _, inst := range res.Instances {
-				name := ""
-				for _, tag := range inst.Tags {
-					if *tag.Key == "Name" {
-						name = *tag.Value
-						break
-					}
-				}
+	if run.running {
+		lines = append(lines,
+			detailValueStyle.Render(progressLine(run.progress, run.started)),
+			"",
+			lipgloss.NewStyle().Foreground(dimColor).Render("[ESC] Cancel"),
+		)
+	} else {
+		lines = append(lines, lipgloss.NewStyle().Foreground(dimColor).Render("[↑↓] Field  [Space] Direction  [Enter] Copy  [ESC] Cancel"))
+	}
 
-				ip := ""
-				if inst.PublicIpAddress != nil {
-					ip = *inst.PublicIpAddress
-				} else if inst.PrivateIpAddress != nil {
-					ip = *inst.PrivateIpAddress
-				}
+	return m.confirmStyle().Render(lipgloss.JoinVertical(lipgloss.Center, lines...))
+}
 
-				zone := ""
-				if inst.Placement != nil {
-					zone = *inst.Placement.AvailabilityZone
-				}
+// renderTunnels lists the tunnels opened from the browser with their
+// traffic
+func (m model) renderTunnels() string {
+	var b strings.Builder
 
-				keyName := ""
-				if inst.KeyName != nil {
-					keyName = *inst.KeyName
-				}
+	b.WriteString(m.titleBarStyle().Render(" relocate "))
+	b.WriteString("\n")
 
-				ami := ""
-				if inst.ImageId != nil {
-					ami = *inst.ImageId
-				}
+	open := 0
+	for _, t := range m.tunnels {
+		if closed, _ := t.status(); !closed {
+			open++
+		}
+	}
+	b.WriteString(m.headerStyle().Render(fmt.Sprintf("Tunnels: %d open", open)))
+	b.WriteString("\n")
+	if m.notice != "" {
+		noticeColor := primaryColor
+		if m.noticeErr {
+			noticeColor = errorColor
+		}
+		b.WriteString(m.headerStyle().Foreground(noticeColor).Render(m.notice))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
 
-				instances = append(instances, EC2Instance{
-					ID:      *inst.InstanceId,
-					Name:    name,
-					IP:      ip,
-					State:   string(inst.State.Name),
-					Type:    string(inst.InstanceType),
-					Zone:    zone,
-					KeyName: keyName,
-					AMI:     ami,
-				})
+	if len(m.tunnels) == 0 {
+		b.WriteString(lipgloss.NewStyle().Foreground(faintColor).Render("No tunnels open. Select an instance and press Ctrl+L to open one."))
+		b.WriteString("\n")
+	}
+	for i, t := range m.tunnels {
+		var icon, status string
+		if closed, err := t.status(); closed {
+			icon = lipgloss.NewStyle().Foreground(errorColor).Render("✕")
+			status = "closed"
+			if err != nil {
+				status = err.Error()
 			}
+		} else {
+			icon = lipgloss.NewStyle().Foreground(successColor).Render("●")
+			status = fmt.Sprintf("open %s  •  %d active, %d total  •  ↑ %s  ↓ %s",
+				time.Since(t.opened).Round(time.Second), t.active.Load(), t.total.Load(),
+				formatBytes(t.sent.Load()), formatBytes(t.received.Load()))
 		}
+		item := fmt.Sprintf("%s %s %s via %s", icon, t.forward.Name, t.forward, instanceLabel(t.inst))
+		if i == m.tunnelIdx {
+			item = lipgloss.NewStyle().Bold(true).Render("> " + item)
+		} else {
+			item = "  " + item
+		}
+		b.WriteString(item)
+		b.WriteString("\n")
+		b.WriteString("    " + lipgloss.NewStyle().Foreground(faintColor).Render(status))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	b.WriteString(m.statusBarStyle().Render("↑↓ tunnel  •  X close  •  Esc back"))
+	return b.String()
+}
 
-		// Sort instances alphabetically by name (or ID if name is empty)
-		slices.SortFunc(instances, func(a, b EC2Instance) int {
-			aName := a.Name
-			if aName == "" {
-				aName = a.ID
+// renderResults shows the status of a broadcast command on every instance
+// and the output of the selected one
+func (m model) renderResults() string {
+	var b strings.Builder
+	run := m.broadcast
+
+	b.WriteString(m.titleBarStyle().Render(" relocate "))
+	b.WriteString("\n")
+
+	done, failed := 0, 0
+	for _, r := range run.results {
+		if r.done {
+			done++
+			if r.exitCode != 0 || r.err != nil {
+				failed++
 			}
-			bName := b.Name
-			if bName == "" {
-				bName = b.ID
+		}
+	}
+	headerParts := []string{
+		"$ " + run.command,
+		fmt.Sprintf("%d/%d done", done, len(run.results)),
+		fmt.Sprintf("%d failed", failed),
+	}
+	if run.running {
+		headerParts = append(headerParts, fmt.Sprintf("%s running…", spinnerFrames[m.spinnerIdx]))
+	}
+	b.WriteString(m.headerStyle().Render(strings.Join(headerParts, "  •  ")))
+	b.WriteString("\n")
+	if m.notice != "" {
+		noticeColor := primaryColor
+		if m.noticeErr {
+			noticeColor = errorColor
+		}
+		b.WriteString(m.headerStyle().Foreground(noticeColor).Render(m.notice))
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	// Instances, scrolled to keep the cursor visible
+	visibleHosts := max(3, (m.height-8)/3)
+	start := max(0, run.cursor-visibleHosts+1)
+	end := min(len(run.results), start+visibleHosts)
+	b.WriteString(sectionHeaderStyle.Render("Instances"))
+	b.WriteString("\n")
+	for i := start; i < end; i++ {
+		r := run.results[i]
+		var icon, status string
+		switch {
+		case !r.done && r.err != nil:
+			icon = lipgloss.NewStyle().Foreground(errorColor).Render("✕")
+			status = r.err.Error()
+		case !r.done:
+			icon = lipgloss.NewStyle().Foreground(primaryColor).Render(spinnerFrames[m.spinnerIdx])
+			status = "running"
+		case r.exitCode < 0:
+			icon = lipgloss.NewStyle().Foreground(errorColor).Render("✕")
+			status = r.err.Error()
+		case r.exitCode != 0:
+			icon = lipgloss.NewStyle().Foreground(errorColor).Render("✕")
+			status = fmt.Sprintf("exit %d after %s", r.exitCode, r.duration.Round(100*time.Millisecond))
+		default:
+			icon = lipgloss.NewStyle().Foreground(successColor).Render("✓")
+			status = fmt.Sprintf("exit 0 after %s", r.duration.Round(100*time.Millisecond))
+		}
+		item := fmt.Sprintf("%s %s %s", icon, instanceLabel(r.inst), lipgloss.NewStyle().Foreground(faintColor).Render(status))
+		if i == run.cursor {
+			item = lipgloss.NewStyle().Bold(true).Render("> " + item)
+		} else {
+			item = "  " + item
+		}
+		b.WriteString(item)
+		b.WriteString("\n")
+	}
+	b.WriteString("\n")
+
+	// Output of the selected instance, its last lines first to go
+	if len(run.results) > 0 {
+		r := run.results[run.cursor]
+		b.WriteString(sectionHeaderStyle.Render("Output: " + instanceLabel(r.inst)))
+		b.WriteString("\n")
+		visibleLines := max(3, m.height-visibleHosts-10)
+		for _, line := range r.output[max(0, len(r.output)-visibleLines):] {
+			b.WriteString(line)
+			b.WriteString("\n")
+		}
+	}
+	b.WriteString("\n")
+
+	keys := "↑↓ instance  •  E export JSON  •  Esc close"
+	if run.running {
+		keys = "↑↓ instance  •  E export JSON  •  Esc stop"
+	}
+	b.WriteString(m.statusBarStyle().Render(keys))
+	return b.String()
+}
+
+// renderStarting shows the progress of the start-and-connect flow
+func (m model) renderStarting() string {
+	lines := []string{
+		lipgloss.NewStyle().Bold(true).Foreground(accentColor).Render("Starting " + instanceLabel(m.actionInst)),
+		"",
+	}
+	for i, step := range m.startSteps {
+		icon := lipgloss.NewStyle().Foreground(successColor).Render("✓")
+		if i == len(m.startSteps)-1 {
+			icon = lipgloss.NewStyle().Foreground(primaryColor).Render(spinnerFrames[m.spinnerIdx])
+			if m.startErr != "" {
+				icon = lipgloss.NewStyle().Foreground(errorColor).Render("✕")
 			}
-			return strings.Compare(aName, bName)
-		})
+		}
+		lines = append(lines, icon+" "+step)
+	}
+
+	keys := "[ESC] Cancel"
+	if m.startErr != "" {
+		lines = append(lines, "", lipgloss.NewStyle().Foreground(errorColor).Render(m.startErr))
+		keys = "[ESC] Close"
+	}
+	lines = append(lines, "", lipgloss.NewStyle().Foreground(dimColor).Render(keys))
+
+	return m.confirmStyle().Render(lipgloss.JoinVertical(lipgloss.Center, lines...))
+}
+
+func (m model) renderStatusBar() string {
+	var parts []string
+
+	if m.session != "" {
+		icon := lipgloss.NewStyle().Foreground(successColor).Render("✓")
+		if m.sessionErr {
+			icon = lipgloss.NewStyle().Foreground(errorColor).Render("✕")
+		}
+		parts = append(parts, icon+" "+m.session)
+	}
 
-		return instancesLoadedMsg{instances: instances}
+	if m.searchIdx > 0 {
+		parts = append(parts, fmt.Sprintf("Saved search: %s", appConfig.SavedSearches[m.searchIdx-1].Name))
+	} else if m.searchQuery != "" {
+		parts = append(parts, fmt.Sprintf("Search: %s", m.searchQuery))
+	}
+
+	parts = append(parts, "↑↓ navigate")
+	parts = append(parts, "Enter connect")
+	parts = append(parts, "Tab/[1-9] env")
+	parts = append(parts, "Ctrl+E recent")
+	parts = append(parts, "Ctrl+T star")
+	parts = append(parts, "Space/* mark")
+	parts = append(parts, "Ctrl+O run command")
+	parts = append(parts, "Ctrl+W tmux")
+	if len(appConfig.Forwards) > 0 {
+		parts = append(parts, "Ctrl+L tunnel")
 	}
+	parts = append(parts, "Ctrl+U transfer")
+	if len(m.tunnels) > 0 {
+		parts = append(parts, "Ctrl+G tunnels")
+	}
+	if len(appConfig.SavedSearches) > 0 {
+		parts = append(parts, "Ctrl+F saved search")
+	}
+	if m.allStates {
+		parts = append(parts, "Ctrl+A running only")
+	} else {
+		parts = append(parts, "Ctrl+A all states")
+	}
+	parts = append(parts, "Ctrl+S/X/K start/stop/reboot")
+	parts = append(parts, "Ctrl+R refresh")
+	if len(m.profiles) > 1 {
+		parts = append(parts, "Ctrl+P profile")
+	}
+	parts = append(parts, "type search")
+	parts = append(parts, "Ctrl+C quit")
+
+	return strings.Join(parts, "  •  ")
 }
 
 func main() {
@@ -763,68 +2535,285 @@ func main() {
 			&cli.StringFlag{
 				Name:    "profile",
 				Aliases: []string{"p"},
-				Usage:   "AWS profile",
-				Value:   "default",
+				Usage:   "AWS profile, comma separated list of profiles, or a glob (e.g. \"team-*\") (default: from config)",
 			},
 			&cli.StringFlag{
 				Name:    "region",
 				Aliases: []string{"r"},
-				Usage:   "AWS region",
-				Value:   "ap-southeast-1",
+				Usage:   "AWS region, comma separated list of regions, or \"all\" (default: from config)",
 			},
 			&cli.StringFlag{
 				Name:    "filter",
 				Aliases: []string{"f"},
 				Usage:   "Filter by tag (e.g., Environment=staging)",
 			},
+			&cli.DurationFlag{
+				Name:    "watch",
+				Aliases: []string{"w"},
+				Usage:   "Refresh the inventory periodically, e.g. 30s (default: only on Ctrl+R)",
+			},
+			&cli.BoolFlag{
+				Name:  "loop",
+				Usage: "Return to the instance browser when a session ends; --loop=false exits instead (default: from config, then false)",
+			},
+			&cli.BoolFlag{
+				Name:  "offline",
+				Usage: "Show the cached inventory without querying AWS",
+			},
 			&cli.StringFlag{
 				Name:    "user",
 				Aliases: []string{"u"},
-				Usage:   "SSH user",
-				Value:   "ubuntu",
+				Usage:   "SSH user (default: from config, then \"ubuntu\")",
+			},
+			&cli.IntFlag{
+				Name:  "port",
+				Usage: "SSH port (default: from config, then 22)",
+			},
+			&cli.StringFlag{
+				Name:    "identity",
+				Aliases: []string{"i"},
+				Usage:   "SSH private key path (default: from config)",
+			},
+			&cli.StringFlag{
+				Name:    "transport",
+				Aliases: []string{"t"},
+				Usage:   "Connection transport: ssh, ssh-ssm, ssm or native (default: from instance tag or config, then ssh)",
+			},
+			&cli.StringFlag{
+				Name:    "address",
+				Aliases: []string{"a"},
+				Usage:   "Address to connect to: public, private, ipv6, public-dns or private-dns, or a comma separated order of preference (default: from config, then public,private,ipv6)",
+			},
+			&cli.StringFlag{
+				Name:  "auth",
+				Usage: "SSH authentication: key or instance-connect (default: from config, then key)",
+			},
+			&cli.StringSliceFlag{
+				Name:    "ssh-option",
+				Aliases: []string{"o"},
+				Usage:   "Extra ssh option, e.g. -o StrictHostKeyChecking=accept-new (repeatable)",
 			},
 		},
 		Action: func(ctx *cli.Context) error {
-			p := tea.NewProgram(
-				initialModel(ctx.String("profile"), ctx.String("region"), ctx.String("filter")),
-				tea.WithAltScreen(),
-			)
+			if err := validateFlags(ctx); err != nil {
+				return err
+			}
 
-			finalModel, err := p.Run()
+			initial, err := initialModel(flagOverrides(ctx), ctx.String("filter"), ctx.Duration("watch"), ctx.Bool("offline"))
 			if err != nil {
 				return err
 			}
 
-			m := finalModel.(model)
-			if m.selected && len(m.filtered) > 0 {
-				inst := m.filtered[m.cursor]
-
-				keyName, err := appConfig.GetSSHKey(m.envMode)
-				if err != nil {
-					return fmt.Errorf("SSH key not configured for %s: %w", m.envMode, err)
-				}
-				keyPath := filepath.Join(os.Getenv("HOME"), ".ssh", keyName)
-
-				// Get SSH user from config or CLI flag
-				sshUser := ctx.String("user")
-				if sshUser == "" && appConfig.Defaults.SSHUser != "" {
-					sshUser = appConfig.Defaults.SSHUser
-				}
-				if sshUser == "" {
-					sshUser = "ubuntu"
-				}
-
-				fmt.Print("\033[H\033[2J")
-				fmt.Printf("Connecting to %s (%s)...\n\n", inst.Name, inst.IP)
-
-				cmd := exec.Command("ssh", "-i", keyPath, sshUser+"@"+inst.IP)
-				cmd.Stdin = os.Stdin
-				cmd.Stdout = os.Stdout
-				cmd.Stderr = os.Stderr
-				return cmd.Run()
-			}
+			_, err = browse(initial, sessionLoop(ctx))
+			return err
+		},
+		Commands: []*cli.Command{
+			{
+				Name:  "last",
+				Usage: "Reconnect to the most recently connected instance",
+				Action: func(ctx *cli.Context) error {
+					if err := validateFlags(ctx); err != nil {
+						return err
+					}
+					history, err := loadHistory()
+					if err != nil {
+						return err
+					}
+					if len(history) == 0 {
+						return fmt.Errorf("no connection history yet")
+					}
 
-			return nil
+					result := runSession(reconnectModel(flagOverrides(ctx), history[len(history)-1]))
+					if err := recordSession(result); err != nil {
+						fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+					}
+					return result.err
+				},
+			},
+			{
+				Name:  "list",
+				Usage: "Print the inventory for scripts, as a table, JSON, JSON Lines, CSV, TSV or YAML",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "output",
+						Aliases: []string{"o"},
+						Value:   listTable,
+						Usage:   "Output format: " + strings.Join(listFormats, ", "),
+					},
+					&cli.StringFlag{
+						Name:    "columns",
+						Aliases: []string{"c"},
+						Usage:   "Comma separated columns, e.g. name,id,private-ip,tag:Owner (default: a summary for table, csv and tsv, every field otherwise)",
+					},
+					&cli.StringFlag{
+						Name:    "query",
+						Aliases: []string{"q"},
+						Usage:   "Only list instances the search matches, as typed in the browser",
+					},
+					&cli.StringFlag{
+						Name:  "template",
+						Usage: "Go text/template printed for each instance, e.g. '{{.Name}} {{.PrivateIP}}'",
+					},
+					&cli.BoolFlag{
+						Name:  "all-states",
+						Usage: "List stopped, pending and stopping instances too",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if err := validateFlags(ctx); err != nil {
+						return err
+					}
+					opts := listOptions{
+						format:    ctx.String("output"),
+						query:     ctx.String("query"),
+						template:  ctx.String("template"),
+						allStates: ctx.Bool("all-states"),
+					}
+					if ctx.IsSet("columns") {
+						opts.columns = strings.Split(ctx.String("columns"), ",")
+					}
+					return runList(flagOverrides(ctx), ctx.String("filter"), ctx.Bool("offline"), opts)
+				},
+			},
+			{
+				Name:        "ssh",
+				Usage:       "Connect to the instance a query names, or run a command on it",
+				ArgsUsage:   "<query | instance ID | tag:Key=Value> [-- command...]",
+				Description: "Flags go before the query; everything after it is the remote command.",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "yes",
+						Aliases: []string{"y"},
+						Usage:   "Connect to instances in protected environments without confirming",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if err := validateFlags(ctx); err != nil {
+						return err
+					}
+					if ctx.NArg() == 0 {
+						return fmt.Errorf("give a search query, instance ID or tag:Key=Value")
+					}
+					command, err := sshCommand(ctx.Args().Tail())
+					if err != nil {
+						return err
+					}
+					return runSSH(ctx, ctx.Args().First(), command)
+				},
+			},
+			{
+				Name:  "ssh-config",
+				Usage: "Write ssh config Host blocks for the inventory to a managed include file",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:  "file",
+						Usage: "Managed file the Host blocks are written to (default: ~/.ssh/config.d/relocate)",
+					},
+					&cli.BoolFlag{
+						Name:  "check",
+						Usage: "Report the hosts that would be added, changed or removed, and fail if any would",
+					},
+					&cli.BoolFlag{
+						Name:  "print",
+						Usage: "Print the Host blocks instead of writing them",
+					},
+					&cli.BoolFlag{
+						Name:  "all-states",
+						Usage: "Include stopped, pending and stopping instances too",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if err := validateFlags(ctx); err != nil {
+						return err
+					}
+					path := ctx.String("file")
+					if path == "" {
+						var err error
+						if path, err = defaultSSHConfigPath(); err != nil {
+							return err
+						}
+					}
+					return runSSHConfig(flagOverrides(ctx), ctx.String("filter"), ctx.Bool("offline"), path, ctx.Bool("all-states"), ctx.Bool("check"), ctx.Bool("print"))
+				},
+			},
+			{
+				Name:      "tunnel",
+				Usage:     "Forward ports through an instance until interrupted",
+				ArgsUsage: "<instance ID or name>",
+				Flags: []cli.Flag{
+					&cli.StringSliceFlag{
+						Name:  "forward",
+						Usage: "Forward preset from config (repeatable)",
+					},
+					&cli.StringSliceFlag{
+						Name:    "local",
+						Aliases: []string{"L"},
+						Usage:   "Local forward, [address:]port:host:hostport as with ssh -L (repeatable)",
+					},
+					&cli.StringSliceFlag{
+						Name:    "remote",
+						Aliases: []string{"R"},
+						Usage:   "Remote forward, [address:]port:host:hostport as with ssh -R (repeatable)",
+					},
+					&cli.StringSliceFlag{
+						Name:    "dynamic",
+						Aliases: []string{"D"},
+						Usage:   "SOCKS5 proxy, [address:]port as with ssh -D (repeatable)",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if err := validateFlags(ctx); err != nil {
+						return err
+					}
+					if ctx.NArg() != 1 {
+						return fmt.Errorf("name the instance to forward through by ID or Name tag")
+					}
+					forwards, err := forwardsFromFlags(ctx)
+					if err != nil {
+						return err
+					}
+					return runTunnels(flagOverrides(ctx), ctx.String("filter"), ctx.Bool("offline"), ctx.Args().First(), forwards)
+				},
+			},
+			{
+				Name:      "cp",
+				Usage:     "Copy files to or from an instance",
+				ArgsUsage: "<source> <destination> (one of them name-or-id:path)",
+				Flags: []cli.Flag{
+					&cli.BoolFlag{
+						Name:    "recursive",
+						Aliases: []string{"r"},
+						Usage:   "Copy directories and their contents",
+					},
+				},
+				Action: func(ctx *cli.Context) error {
+					if err := validateFlags(ctx); err != nil {
+						return err
+					}
+					if ctx.NArg() != 2 {
+						return fmt.Errorf("give a source and a destination, one of them name-or-id:path")
+					}
+					return runCopy(flagOverrides(ctx), ctx.String("filter"), ctx.Bool("offline"), ctx.Args().Get(0), ctx.Args().Get(1), ctx.Bool("recursive"))
+				},
+			},
+			{
+				Name:  "cache",
+				Usage: "Manage the cached inventory",
+				Subcommands: []*cli.Command{
+					{
+						Name:  "clear",
+						Usage: "Remove every cached inventory",
+						Action: func(ctx *cli.Context) error {
+							n, err := clearCache()
+							if err != nil {
+								return err
+							}
+							fmt.Printf("Removed %d cached inventories\n", n)
+							return nil
+						},
+					},
+				},
+			},
 		},
 	}
 
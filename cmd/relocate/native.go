@@ -0,0 +1,701 @@
+package main
+
+import (
+	"bufio"
+	"cmp"
+	"context"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/muesli/cancelreader"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+	"golang.org/x/crypto/ssh/knownhosts"
+	"golang.org/x/term"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// nativeConnectTimeout bounds connecting and the SSH handshake unless the
+// ConnectTimeout option is set
+const nativeConnectTimeout = 15 * time.Second
+
+// nativeOptions are the ssh -o options the built-in client honours. Other
+// options are ignored.
+type nativeOptions struct {
+	strictHostKeyChecking string // yes, no, accept-new or ask
+	knownHostsFile        string
+	forwardAgent          bool
+	identitiesOnly        bool // use only the key file, not the ssh-agent
+	connectTimeout        time.Duration
+	batchMode             bool // never prompt
+}
+
+// parseNativeOptions reads ssh -o options, "Name=value" or "Name value".
+// As with ssh, the first value given for an option wins.
+func parseNativeOptions(options []string) (nativeOptions, error) {
+	opts := nativeOptions{connectTimeout: nativeConnectTimeout}
+	seen := make(map[string]bool)
+	for _, opt := range options {
+		name, value, ok := strings.Cut(opt, "=")
+		if !ok {
+			name, value, _ = strings.Cut(opt, " ")
+		}
+		name = strings.ToLower(strings.TrimSpace(name))
+		value = strings.TrimSpace(value)
+		if seen[name] {
+			continue
+		}
+		seen[name] = true
+
+		switch name {
+		case "stricthostkeychecking":
+			switch strings.ToLower(value) {
+			case "yes", "no", "accept-new", "ask":
+				opts.strictHostKeyChecking = strings.ToLower(value)
+			case "off":
+				opts.strictHostKeyChecking = "no"
+			default:
+				return opts, fmt.Errorf("StrictHostKeyChecking: unsupported value %q", value)
+			}
+		case "userknownhostsfile":
+			// Only the first of several files is used
+			opts.knownHostsFile, _, _ = strings.Cut(value, " ")
+		case "forwardagent":
+			opts.forwardAgent = strings.EqualFold(value, "yes")
+		case "identitiesonly":
+			opts.identitiesOnly = strings.EqualFold(value, "yes")
+		case "batchmode":
+			opts.batchMode = strings.EqualFold(value, "yes")
+		case "connecttimeout":
+			seconds, err := strconv.Atoi(value)
+			if err != nil || seconds < 0 {
+				return opts, fmt.Errorf("ConnectTimeout: invalid value %q", value)
+			}
+			if seconds > 0 {
+				opts.connectTimeout = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+
+	if opts.strictHostKeyChecking == "" {
+		opts.strictHostKeyChecking = "ask"
+	}
+	if opts.knownHostsFile == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return opts, err
+		}
+		opts.knownHostsFile = filepath.Join(home, ".ssh", "known_hosts")
+	} else if strings.HasPrefix(opts.knownHostsFile, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return opts, err
+		}
+		opts.knownHostsFile = filepath.Join(home, opts.knownHostsFile[2:])
+	}
+	return opts, nil
+}
+
+// nativeDialer opens SSH connections with the built-in client. Connections
+// to bastions are kept open and shared by every connection through them
+// until the dialer is closed.
+type nativeDialer struct {
+	interactive bool // may prompt on the terminal for passphrases and host keys
+
+	mu        sync.Mutex
+	jumps     map[string]*ssh.Client // by ProxyJump spec
+	signers   map[string]ssh.Signer  // parsed key files, by path
+	agent     agent.ExtendedAgent
+	agentConn net.Conn
+
+	knownHostsMu sync.Mutex // serializes reading and appending known_hosts
+}
+
+// newNativeDialer returns a dialer. An interactive dialer may prompt for key
+// passphrases and to accept unknown host keys.
+func newNativeDialer(interactive bool) *nativeDialer {
+	d := &nativeDialer{
+		interactive: interactive,
+		jumps:       make(map[string]*ssh.Client),
+		signers:     make(map[string]ssh.Signer),
+	}
+	if sock := os.Getenv("SSH_AUTH_SOCK"); sock != "" {
+		if conn, err := net.Dial("unix", sock); err == nil {
+			d.agentConn = conn
+			d.agent = agent.NewClient(conn)
+		}
+	}
+	return d
+}
+
+// Close closes the bastion connections and the ssh-agent connection
+func (d *nativeDialer) Close() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for spec, client := range d.jumps {
+		client.Close()
+		delete(d.jumps, spec)
+	}
+	if d.agentConn != nil {
+		d.agentConn.Close()
+	}
+}
+
+// dial connects to target: directly or through its bastion over the ssh and
+// native transports, or through an SSM tunnel over the ssh-ssm transport
+func (d *nativeDialer) dial(ctx context.Context, target remoteTarget) (*ssh.Client, nativeOptions, error) {
+	conn := target.conn
+	opts, err := parseNativeOptions(conn.Options)
+	if err != nil {
+		return nil, opts, err
+	}
+
+	var netConn net.Conn
+	var addr string
+	switch target.transport {
+	case config.TransportSSHSSM:
+		addr = net.JoinHostPort(target.inst.ID, strconv.Itoa(conn.Port))
+		netConn, err = dialSSM(ctx, target.inst, conn.Port, addr)
+
+	case config.TransportSSH, config.TransportNative, "":
+		kind := conn.Address
+		if kind == "" {
+			kind, _ = preferredAddress(target.inst, conn.Addresses)
+		}
+		host := target.inst.Address(kind)
+		if host == "" {
+			return nil, opts, fmt.Errorf("%s has no %s address (try the %s transport)", target.inst.ID, strings.Join(conn.Addresses, ", "), config.TransportSSHSSM)
+		}
+		addr = net.JoinHostPort(host, strconv.Itoa(conn.Port))
+		if conn.ProxyJump != "" {
+			// The bastion gets the configured key or the ssh-agent's keys,
+			// never the instance's ephemeral key
+			netConn, err = d.dialJump(ctx, conn.ProxyJump, conn.JumpKeyPath, opts, addr)
+		} else {
+			dialer := net.Dialer{Timeout: opts.connectTimeout}
+			netConn, err = dialer.DialContext(ctx, "tcp", addr)
+		}
+
+	default:
+		return nil, opts, fmt.Errorf("the %s transport cannot use the built-in SSH client", target.transport)
+	}
+	if err != nil {
+		return nil, opts, err
+	}
+
+	instanceOpts := opts
+	if usesEphemeralKey(conn) {
+		instanceOpts.identitiesOnly = true
+	}
+	client, err := d.handshake(ctx, netConn, addr, conn.User, conn.KeyPath, instanceOpts)
+	return client, opts, err
+}
+
+// dialJump opens a channel to addr through the bastion of spec,
+// user@host[:port], connecting to the bastion first unless it is connected
+func (d *nativeDialer) dialJump(ctx context.Context, spec, keyPath string, opts nativeOptions, addr string) (net.Conn, error) {
+	d.mu.Lock()
+	client, ok := d.jumps[spec]
+	d.mu.Unlock()
+
+	if !ok {
+		user, host, _ := strings.Cut(spec, "@")
+		if _, _, err := net.SplitHostPort(host); err != nil {
+			host = net.JoinHostPort(host, strconv.Itoa(config.DefaultSSHPort))
+		}
+		dialer := net.Dialer{Timeout: opts.connectTimeout}
+		netConn, err := dialer.DialContext(ctx, "tcp", host)
+		if err != nil {
+			return nil, fmt.Errorf("bastion %s: %w", spec, err)
+		}
+		client, err = d.handshake(ctx, netConn, host, user, keyPath, opts)
+		if err != nil {
+			return nil, fmt.Errorf("bastion %s: %w", spec, err)
+		}
+
+		d.mu.Lock()
+		if existing, ok := d.jumps[spec]; ok {
+			// Another connection got there first
+			client.Close()
+			client = existing
+		} else {
+			d.jumps[spec] = client
+		}
+		d.mu.Unlock()
+	}
+
+	netConn, err := client.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		var refused *ssh.OpenChannelError
+		if ctx.Err() == nil && !errors.As(err, &refused) {
+			// The bastion connection dropped; connect again next time
+			d.mu.Lock()
+			if d.jumps[spec] == client {
+				delete(d.jumps, spec)
+				client.Close()
+			}
+			d.mu.Unlock()
+		}
+		return nil, fmt.Errorf("bastion %s: %w", spec, err)
+	}
+	return netConn, nil
+}
+
+// handshake runs the SSH handshake on netConn and authenticates as user.
+// netConn is closed if it fails.
+func (d *nativeDialer) handshake(ctx context.Context, netConn net.Conn, addr, user, keyPath string, opts nativeOptions) (*ssh.Client, error) {
+	auth, err := d.authMethod(keyPath, opts)
+	if err != nil {
+		netConn.Close()
+		return nil, err
+	}
+	cfg := &ssh.ClientConfig{
+		User:              user,
+		Auth:              []ssh.AuthMethod{auth},
+		HostKeyCallback:   d.hostKeyCallback(opts),
+		HostKeyAlgorithms: d.hostKeyAlgorithms(opts, addr, netConn.RemoteAddr()),
+	}
+
+	// Prompting for a host key must not be cut short by the timeout
+	if !d.interactive || opts.batchMode {
+		netConn.SetDeadline(time.Now().Add(opts.connectTimeout))
+	}
+	stop := context.AfterFunc(ctx, func() { netConn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(netConn, addr, cfg)
+	stop()
+	if err != nil {
+		netConn.Close()
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, err
+	}
+	netConn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// authMethod offers the key file and, unless IdentitiesOnly is set, the keys
+// of the ssh-agent
+func (d *nativeDialer) authMethod(keyPath string, opts nativeOptions) (ssh.AuthMethod, error) {
+	var signers []ssh.Signer
+	if keyPath != "" {
+		signer, err := d.signer(keyPath, opts)
+		if err != nil {
+			return nil, err
+		}
+		signers = append(signers, signer)
+	}
+
+	useAgent := d.agent != nil && !opts.identitiesOnly
+	if len(signers) == 0 && !useAgent {
+		return nil, fmt.Errorf("%w and no ssh-agent is running", config.ErrSSHKeyNotConfigured)
+	}
+	return ssh.PublicKeysCallback(func() ([]ssh.Signer, error) {
+		if !useAgent {
+			return signers, nil
+		}
+		agentSigners, err := d.agent.Signers()
+		if err != nil {
+			return signers, nil
+		}
+		return append(signers, agentSigners...), nil
+	}), nil
+}
+
+// signer parses a private key file, prompting for its passphrase if it is
+// encrypted and the dialer is interactive
+func (d *nativeDialer) signer(keyPath string, opts nativeOptions) (ssh.Signer, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if signer, ok := d.signers[keyPath]; ok {
+		return signer, nil
+	}
+
+	data, err := os.ReadFile(keyPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read key: %w", err)
+	}
+	signer, err := ssh.ParsePrivateKey(data)
+	var missing *ssh.PassphraseMissingError
+	if errors.As(err, &missing) {
+		fd := int(os.Stdin.Fd())
+		if !d.interactive || opts.batchMode || !term.IsTerminal(fd) {
+			return nil, fmt.Errorf("%s is encrypted (add it to the ssh-agent to use it without a terminal)", keyPath)
+		}
+		fmt.Fprintf(os.Stderr, "Enter passphrase for key '%s': ", keyPath)
+		passphrase, readErr := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		if readErr != nil {
+			return nil, readErr
+		}
+		signer, err = ssh.ParsePrivateKeyWithPassphrase(data, passphrase)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", keyPath, err)
+	}
+	d.signers[keyPath] = signer
+	return signer, nil
+}
+
+// knownHosts returns the known_hosts check of file, or nil if the file does
+// not exist yet
+func knownHosts(file string) (ssh.HostKeyCallback, error) {
+	check, err := knownhosts.New(file)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return check, err
+}
+
+// hostKeyCallback verifies host keys against the known_hosts file as
+// StrictHostKeyChecking asks: a changed key is always refused unless it is
+// "no", and an unknown key is refused (yes), added (accept-new, no) or
+// added once confirmed on the terminal (ask).
+func (d *nativeDialer) hostKeyCallback(opts nativeOptions) ssh.HostKeyCallback {
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		d.knownHostsMu.Lock()
+		defer d.knownHostsMu.Unlock()
+
+		check, err := knownHosts(opts.knownHostsFile)
+		if err != nil {
+			return err
+		}
+		if check != nil {
+			err = check(hostname, remote, key)
+		} else {
+			err = &knownhosts.KeyError{}
+		}
+
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) > 0 {
+			if opts.strictHostKeyChecking == "no" {
+				return nil
+			}
+			return fmt.Errorf("the host key of %s has changed to %s %s (see %s): someone could be eavesdropping, or the instance was replaced", knownhosts.Normalize(hostname), key.Type(), fingerprint, keyErr.Want[0].String())
+		}
+
+		switch opts.strictHostKeyChecking {
+		case "yes":
+			return fmt.Errorf("no host key is known for %s (%s %s) in %s", knownhosts.Normalize(hostname), key.Type(), fingerprint, opts.knownHostsFile)
+		case "ask":
+			if !d.interactive || opts.batchMode || !term.IsTerminal(int(os.Stdin.Fd())) {
+				return fmt.Errorf("no host key is known for %s (%s %s) and there is no terminal to confirm it (set StrictHostKeyChecking=accept-new to add it)", knownhosts.Normalize(hostname), key.Type(), fingerprint)
+			}
+			fmt.Fprintf(os.Stderr, "The authenticity of host '%s' can't be established.\n", knownhosts.Normalize(hostname))
+			fmt.Fprintf(os.Stderr, "%s key fingerprint is %s.\n", key.Type(), fingerprint)
+			fmt.Fprint(os.Stderr, "Are you sure you want to continue connecting (yes/no)? ")
+			answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+			if strings.TrimSpace(strings.ToLower(answer)) != "yes" {
+				return errors.New("host key verification failed")
+			}
+		}
+		return addKnownHost(opts.knownHostsFile, hostname, key)
+	}
+}
+
+// addKnownHost appends a host key to the known_hosts file
+func addKnownHost(file, hostname string, key ssh.PublicKey) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to record host key: %w", err)
+	}
+	defer f.Close()
+	_, err = fmt.Fprintln(f, knownhosts.Line([]string{hostname}, key))
+	return err
+}
+
+// hostKeyAlgorithms returns the algorithms of the host keys known for addr,
+// so that the server presents a key that can be verified rather than one of
+// another type, or nil to accept any algorithm for an unknown host
+func (d *nativeDialer) hostKeyAlgorithms(opts nativeOptions, addr string, remote net.Addr) []string {
+	d.knownHostsMu.Lock()
+	defer d.knownHostsMu.Unlock()
+
+	check, err := knownHosts(opts.knownHostsFile)
+	if err != nil || check == nil {
+		return nil
+	}
+	// Any key that is not known reports the known ones
+	pub, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		return nil
+	}
+	probe, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil
+	}
+	var keyErr *knownhosts.KeyError
+	if !errors.As(check(addr, remote, probe), &keyErr) {
+		return nil
+	}
+
+	var algorithms []string
+	for _, known := range keyErr.Want {
+		if known.Key.Type() == ssh.KeyAlgoRSA {
+			algorithms = append(algorithms, ssh.KeyAlgoRSASHA512, ssh.KeyAlgoRSASHA256)
+		}
+		algorithms = append(algorithms, known.Key.Type())
+	}
+	return algorithms
+}
+
+// dialNative connects to target with a new dialer, pushing an EC2 Instance
+// Connect key first when that is the auth method. The ssm transport is
+// carried as ssh-ssm. An interactive connection may prompt for a key
+// passphrase or an unknown host key. Close the dialer once done with the
+// client.
+func dialNative(target remoteTarget, interactive bool) (*nativeDialer, *ssh.Client, error) {
+	if target.transport == config.TransportSSM {
+		target.transport = config.TransportSSHSSM
+	}
+	conn := target.conn
+	if conn.Auth == config.AuthInstanceConnect {
+		// The key is only needed to authenticate
+		key, err := useInstanceConnect(target.inst, &conn)
+		switch {
+		case err == nil:
+			defer key.Remove()
+		case conn.KeyPath == "":
+			return nil, nil, err
+		}
+	}
+	if !interactive {
+		conn.Options = append(conn.Options, "BatchMode=yes")
+	}
+	target.conn = conn
+
+	dialer := newNativeDialer(interactive)
+	client, _, err := dialer.dial(context.Background(), target)
+	if err != nil {
+		dialer.Close()
+		return nil, nil, err
+	}
+	return dialer, client, nil
+}
+
+// exec runs command on target without a terminal and passes every line of
+// its combined output to output. It returns the remote exit status, or -1 if
+// the command could not be run.
+func (d *nativeDialer) exec(ctx context.Context, target remoteTarget, command string, output func(string)) (int, error) {
+	client, _, err := d.dial(ctx, target)
+	if err != nil {
+		return -1, err
+	}
+	defer client.Close()
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return -1, err
+	}
+	defer session.Close()
+
+	pr, pw := io.Pipe()
+	session.Stdout = pw
+	session.Stderr = pw
+	scanned := scanLines(pr, output)
+	err = session.Run(command)
+	pw.Close()
+	<-scanned
+
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus(), nil
+	case ctx.Err() != nil:
+		return -1, ctx.Err()
+	default:
+		return -1, err
+	}
+}
+
+// runNativeSession opens an interactive shell on target with the built-in
+// client, or runs command when it is not empty, attached to the terminal,
+// and returns the remote exit status once it ends
+func runNativeSession(target remoteTarget, command string) (int, error) {
+	d := newNativeDialer(true)
+	defer d.Close()
+
+	client, opts, err := d.dial(context.Background(), target)
+	if err != nil {
+		return -1, err
+	}
+	defer client.Close()
+
+	session, err := client.NewSession()
+	if err != nil {
+		return -1, err
+	}
+	defer session.Close()
+
+	if opts.forwardAgent && d.agent != nil {
+		if err := agent.ForwardToAgent(client, d.agent); err != nil {
+			return -1, err
+		}
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			return -1, err
+		}
+	}
+
+	// As with ssh, a remote command runs without a terminal
+	fd := int(os.Stdin.Fd())
+	if command == "" && term.IsTerminal(fd) {
+		width, height, err := term.GetSize(fd)
+		if err != nil {
+			width, height = 80, 24
+		}
+		modes := ssh.TerminalModes{
+			ssh.ECHO:          1,
+			ssh.TTY_OP_ISPEED: 14400,
+			ssh.TTY_OP_OSPEED: 14400,
+		}
+		if err := session.RequestPty(cmp.Or(os.Getenv("TERM"), "xterm-256color"), height, width, modes); err != nil {
+			return -1, err
+		}
+
+		state, err := term.MakeRaw(fd)
+		if err != nil {
+			return -1, err
+		}
+		defer term.Restore(fd, state)
+		defer watchWindowSize(fd, session)()
+	}
+
+	// A cancelable reader, so that no keystroke typed after the session
+	// ends is lost to it
+	stdin, err := cancelreader.NewReader(os.Stdin)
+	if err != nil {
+		return -1, err
+	}
+	defer stdin.Close()
+	defer stdin.Cancel()
+
+	remoteStdin, err := session.StdinPipe()
+	if err != nil {
+		return -1, err
+	}
+	session.Stdout = os.Stdout
+	session.Stderr = os.Stderr
+	if command != "" {
+		err = session.Start(command)
+	} else {
+		err = session.Shell()
+	}
+	if err != nil {
+		return -1, err
+	}
+	go func() {
+		io.Copy(remoteStdin, stdin)
+		remoteStdin.Close()
+	}()
+
+	err = session.Wait()
+	var exitErr *ssh.ExitError
+	switch {
+	case err == nil:
+		return 0, nil
+	case errors.As(err, &exitErr):
+		return exitErr.ExitStatus(), nil
+	default:
+		return -1, err
+	}
+}
+
+// scanLines passes every line read from r to output. The returned channel is
+// closed once r is drained.
+func scanLines(r io.Reader, output func(string)) <-chan struct{} {
+	scanned := make(chan struct{})
+	go func() {
+		defer close(scanned)
+		scanner := bufio.NewScanner(r)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			output(scanner.Text())
+		}
+		io.Copy(io.Discard, r)
+	}()
+	return scanned
+}
+
+// ssmAddr is the address of an SSM tunnel: the instance ID and port, as
+// ssh names it in known_hosts
+type ssmAddr string
+
+func (a ssmAddr) Network() string { return "ssm" }
+func (a ssmAddr) String() string  { return string(a) }
+
+// ssmConn is an SSH stream tunnelled through an aws ssm start-session
+// process
+type ssmConn struct {
+	cmd    *exec.Cmd
+	stdin  io.WriteCloser
+	stdout io.ReadCloser
+	addr   ssmAddr
+}
+
+// dialSSM starts an SSM session that tunnels to port on inst, the way the
+// ssh-ssm transport's ProxyCommand does
+func dialSSM(ctx context.Context, inst EC2Instance, port int, addr string) (net.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	args := append([]string{"ssm", "start-session",
+		"--target", inst.ID,
+		"--document-name", "AWS-StartSSHSession",
+		"--parameters", "portNumber=" + strconv.Itoa(port),
+	}, awsCLIArgs(inst)...)
+	cmd := exec.Command("aws", args...)
+	cmd.Stderr = io.Discard
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start SSM session: %w", err)
+	}
+	return &ssmConn{cmd: cmd, stdin: stdin, stdout: stdout, addr: ssmAddr(addr)}, nil
+}
+
+func (c *ssmConn) Read(b []byte) (int, error)  { return c.stdout.Read(b) }
+func (c *ssmConn) Write(b []byte) (int, error) { return c.stdin.Write(b) }
+
+// Close ends the SSM session
+func (c *ssmConn) Close() error {
+	c.stdin.Close()
+	if c.cmd.Process != nil {
+		c.cmd.Process.Kill()
+	}
+	c.cmd.Wait()
+	return nil
+}
+
+func (c *ssmConn) LocalAddr() net.Addr                { return ssmAddr("local") }
+func (c *ssmConn) RemoteAddr() net.Addr               { return c.addr }
+func (c *ssmConn) SetDeadline(t time.Time) error      { return nil }
+func (c *ssmConn) SetReadDeadline(t time.Time) error  { return nil }
+func (c *ssmConn) SetWriteDeadline(t time.Time) error { return nil }
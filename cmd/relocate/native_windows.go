@@ -0,0 +1,11 @@
+//go:build windows
+
+package main
+
+import "golang.org/x/crypto/ssh"
+
+// watchWindowSize does nothing on Windows, which has no SIGWINCH; the remote
+// terminal keeps the size it was opened with
+func watchWindowSize(fd int, session *ssh.Session) func() {
+	return func() {}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// expandProfiles resolves --profile values into profile names. Values that
+// contain glob characters are matched against the profiles declared in the
+// shared AWS config and credentials files.
+func expandProfiles(patterns []string) ([]string, error) {
+	var profiles []string
+	var known []string
+
+	for _, pattern := range patterns {
+		if !strings.ContainsAny(pattern, "*?[") {
+			if !slices.Contains(profiles, pattern) {
+				profiles = append(profiles, pattern)
+			}
+			continue
+		}
+
+		if known == nil {
+			var err error
+			known, err = sharedProfiles()
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		matched := false
+		for _, profile := range known {
+			ok, err := config.MatchProfile(pattern, profile)
+			if err != nil {
+				return nil, fmt.Errorf("invalid profile pattern %q: %w", pattern, err)
+			}
+			if ok {
+				matched = true
+				if !slices.Contains(profiles, profile) {
+					profiles = append(profiles, profile)
+				}
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("no AWS profile matches %q", pattern)
+		}
+	}
+
+	return profiles, nil
+}
+
+// sharedProfiles lists the profile names declared in ~/.aws/config and
+// ~/.aws/credentials (or AWS_CONFIG_FILE / AWS_SHARED_CREDENTIALS_FILE)
+func sharedProfiles() ([]string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	configFile := os.Getenv("AWS_CONFIG_FILE")
+	if configFile == "" {
+		configFile = filepath.Join(homeDir, ".aws", "config")
+	}
+	credentialsFile := os.Getenv("AWS_SHARED_CREDENTIALS_FILE")
+	if credentialsFile == "" {
+		credentialsFile = filepath.Join(homeDir, ".aws", "credentials")
+	}
+
+	var profiles []string
+	for _, file := range []struct {
+		path     string
+		prefixed bool
+	}{
+		{configFile, true},
+		{credentialsFile, false},
+	} {
+		names, err := readProfileSections(file.path, file.prefixed)
+		if err != nil {
+			return nil, err
+		}
+		for _, name := range names {
+			if !slices.Contains(profiles, name) {
+				profiles = append(profiles, name)
+			}
+		}
+	}
+
+	slices.Sort(profiles)
+	return profiles, nil
+}
+
+// readProfileSections returns the profile section names of an AWS ini file.
+// In the config file sections other than [default] are written as
+// [profile name]; the credentials file uses bare [name] sections.
+func readProfileSections(file string, prefixed bool) ([]string, error) {
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if !strings.HasPrefix(line, "[") || !strings.HasSuffix(line, "]") {
+			continue
+		}
+		section := strings.TrimSpace(line[1 : len(line)-1])
+
+		if prefixed && section != "default" {
+			name, ok := strings.CutPrefix(section, "profile ")
+			if !ok {
+				continue // sso-session, services, ...
+			}
+			section = strings.TrimSpace(name)
+		}
+		names = append(names, section)
+	}
+
+	return names, scanner.Err()
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"slices"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Changes marked on instances by a refresh, compared with the previous load
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeState   = "state"
+)
+
+// refreshTickMsg asks for a refresh when --watch is set
+type refreshTickMsg struct{}
+
+// watchTick schedules the next periodic refresh, or nothing if interval is 0
+func watchTick(interval time.Duration) tea.Cmd {
+	if interval <= 0 {
+		return nil
+	}
+	return tea.Tick(interval, func(time.Time) tea.Msg {
+		return refreshTickMsg{}
+	})
+}
+
+// diffInventory marks the instances of fresh that are new or changed state
+// since old, and keeps the instances of old that disappeared, marked as
+// removed. Instances already marked removed in old are dropped. Instances
+// of the profile/region targets in uncovered, which old has no inventory
+// for, are not marked new.
+func diffInventory(old, fresh []EC2Instance, uncovered map[string]bool) []EC2Instance {
+	before := make(map[string]EC2Instance, len(old))
+	for _, inst := range old {
+		if inst.Change != changeRemoved {
+			before[inst.ID] = inst
+		}
+	}
+
+	for i := range fresh {
+		prev, ok := before[fresh[i].ID]
+		switch {
+		case !ok && coveredBy(uncovered, fresh[i]):
+			fresh[i].Change = changeAdded
+		case !ok:
+			// Not cached before, so not known to be new
+			fresh[i].Change = ""
+		case prev.State != fresh[i].State:
+			fresh[i].Change = changeState
+		default:
+			fresh[i].Change = ""
+		}
+		delete(before, fresh[i].ID)
+	}
+
+	for _, inst := range old {
+		if _, gone := before[inst.ID]; gone {
+			inst.Change = changeRemoved
+			fresh = append(fresh, inst)
+		}
+	}
+	sortInstances(fresh)
+	return fresh
+}
+
+// coveredBy reports whether inst belongs to none of the uncovered targets,
+// keyed "profile/region" by the requested region, which may be "all"
+func coveredBy(uncovered map[string]bool, inst EC2Instance) bool {
+	return !uncovered[inst.Profile+"/"+inst.Region] && !uncovered[inst.Profile+"/"+allRegions]
+}
+
+// applySSMStatus records the SSM agent status reported for the instances
+// of one discovery target
+func applySSMStatus(instances []EC2Instance, msg ssmStatusMsg) {
+	for i := range instances {
+		if !slices.Contains(msg.checked, instances[i].ID) {
+			continue
+		}
+		if status, ok := msg.status[instances[i].ID]; ok {
+			instances[i].SSMStatus = status
+		} else {
+			instances[i].SSMStatus = ssmNotManaged
+		}
+	}
+}
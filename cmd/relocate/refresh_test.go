@@ -0,0 +1,64 @@
+package main
+
+import (
+	"maps"
+	"testing"
+)
+
+func TestDiffInventory(t *testing.T) {
+	inst := func(id, region, state, change string) EC2Instance {
+		return EC2Instance{ID: id, Name: "web-" + id, Profile: "dev", Region: region, State: state, Change: change}
+	}
+
+	tests := []struct {
+		name      string
+		old       []EC2Instance
+		fresh     []EC2Instance
+		uncovered map[string]bool
+		want      map[string]string // change by instance ID
+	}{
+		{
+			name:  "unchanged",
+			old:   []EC2Instance{inst("1", "us-east-1", "running", "")},
+			fresh: []EC2Instance{inst("1", "us-east-1", "running", "")},
+			want:  map[string]string{"1": ""},
+		},
+		{
+			name:  "added, changed state and removed",
+			old:   []EC2Instance{inst("1", "us-east-1", "running", ""), inst("2", "us-east-1", "running", "")},
+			fresh: []EC2Instance{inst("2", "us-east-1", "stopped", ""), inst("3", "us-east-1", "running", "")},
+			want:  map[string]string{"1": changeRemoved, "2": changeState, "3": changeAdded},
+		},
+		{
+			name:  "marks of the previous refresh are cleared",
+			old:   []EC2Instance{inst("1", "us-east-1", "running", changeAdded), inst("2", "us-east-1", "running", changeRemoved)},
+			fresh: []EC2Instance{inst("1", "us-east-1", "running", changeAdded)},
+			want:  map[string]string{"1": ""},
+		},
+		{
+			name:      "region missing from the cache",
+			old:       []EC2Instance{inst("1", "us-east-1", "running", "")},
+			fresh:     []EC2Instance{inst("1", "us-east-1", "running", ""), inst("2", "eu-west-1", "running", ""), inst("3", "us-east-1", "running", "")},
+			uncovered: map[string]bool{"dev/eu-west-1": true},
+			want:      map[string]string{"1": "", "2": "", "3": changeAdded},
+		},
+		{
+			name:      "every region of a profile missing from the cache",
+			fresh:     []EC2Instance{inst("1", "us-east-1", "running", ""), inst("2", "eu-west-1", "stopped", "")},
+			uncovered: map[string]bool{"dev/" + allRegions: true},
+			want:      map[string]string{"1": "", "2": ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := make(map[string]string)
+			for _, inst := range diffInventory(tt.old, tt.fresh, tt.uncovered) {
+				got[inst.ID] = inst.Change
+			}
+			if !maps.Equal(got, tt.want) {
+				t.Errorf("changes = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// sessionResult describes a finished session
+type sessionResult struct {
+	inst        EC2Instance
+	target      string // address connected to, or the instance ID over SSM
+	addressKind string
+	user        string
+	transport   string
+	started     time.Time
+	duration    time.Duration
+	exitCode    int   // -1 if the session could not be started
+	err         error // why the session could not be started, or ended abnormally
+}
+
+// runSession connects to the instance selected in m and returns once the
+// session ends. A remote command in m runs instead of a shell, without a
+// terminal.
+func runSession(m model) sessionResult {
+	inst := m.target
+	result := sessionResult{inst: inst, target: inst.ID, transport: m.transport, started: time.Now(), exitCode: -1}
+	if config.DirectTransport(m.transport) {
+		result.target = inst.Address(m.address)
+		result.addressKind = m.address
+	}
+
+	// Resolve user, port, key and options: CLI flag > environment > defaults
+	conn := m.connection(inst)
+	conn.Address = m.address
+	result.user = conn.User
+	if jump, ok := m.bastion(inst, conn); ok {
+		conn.ProxyJump = jump.spec
+	}
+
+	// Push an ephemeral key with EC2 Instance Connect, falling back
+	// to the configured key if that fails
+	if conn.Auth == config.AuthInstanceConnect && m.transport != config.TransportSSM {
+		key, err := useInstanceConnect(inst, &conn)
+		switch {
+		case err == nil:
+			defer key.Remove()
+		case conn.KeyPath != "":
+			fmt.Fprintf(os.Stderr, "Warning: %v; using %s\n", err, conn.KeyPath)
+		default:
+			result.err = err
+			return result
+		}
+	}
+
+	var cmd *exec.Cmd
+	if m.transport != config.TransportNative {
+		var err error
+		if cmd, err = connectCommand(inst, conn, m.transport, m.command...); err != nil {
+			result.err = err
+			return result
+		}
+	}
+
+	// The output of a remote command is left to the command
+	if len(m.command) == 0 {
+		fmt.Print("\033[H\033[2J")
+		if conn.ProxyJump != "" {
+			fmt.Printf("Connecting to %s (%s) via %s through %s...\n\n", inst.Name, result.target, m.transport, conn.ProxyJump)
+		} else {
+			fmt.Printf("Connecting to %s (%s) via %s...\n\n", inst.Name, result.target, m.transport)
+		}
+	}
+
+	if cmd == nil {
+		// The built-in client reports the remote exit status itself
+		result.exitCode, result.err = runNativeSession(remoteTarget{inst: inst, conn: conn, transport: m.transport}, strings.Join(m.command, " "))
+		result.duration = time.Since(result.started)
+		return result
+	}
+
+	err := cmd.Run()
+	result.duration = time.Since(result.started)
+
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		result.exitCode = 0
+	case errors.As(err, &exitErr):
+		result.exitCode = exitErr.ExitCode()
+		result.err = err
+	default:
+		result.err = err
+	}
+	return result
+}
+
+// String summarizes the session for the status bar
+func (r sessionResult) String() string {
+	name := instanceLabel(r.inst)
+	switch {
+	case r.exitCode < 0 && r.err != nil:
+		return fmt.Sprintf("%s: %v", name, r.err)
+	case r.exitCode == 0:
+		return fmt.Sprintf("%s: exited after %s", name, r.duration.Round(time.Second))
+	default:
+		return fmt.Sprintf("%s: exit status %d after %s", name, r.exitCode, r.duration.Round(time.Second))
+	}
+}
+
+// resume prepares the model to be shown again after a session
+func (m model) resume(result sessionResult) model {
+	m = m.reopen()
+	m.session = result.String()
+	m.sessionErr = result.exitCode != 0
+	if result.exitCode >= 0 {
+		m.history = append(m.history, newHistoryEntry(result))
+		m.frecency = frecencyScores(m.history, time.Now())
+		m.filterInstances()
+	}
+	return m
+}
+
+// reopen prepares the model to be shown again after the browser closed.
+// Discovery that was still running when it closed is started again.
+func (m model) reopen() model {
+	m.selected = false
+	m.mode = viewNormal
+	if m.loading {
+		m.instances = nil
+		m.filtered = nil
+		m.cursor = 0
+	}
+	m.incoming = nil
+	return m
+}
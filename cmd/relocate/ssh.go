@@ -0,0 +1,133 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/urfave/cli/v2"
+	"golang.org/x/term"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// runSSH connects to the instance a query names without the browser, running
+// command instead of a shell when one is given. When several instances
+// match, the browser opens listing just them. The remote exit status becomes
+// relocate's.
+func runSSH(ctx *cli.Context, query string, command []string) error {
+	overrides := flagOverrides(ctx)
+	instances, warnings, err := fetchInventory(overrides, ctx.String("filter"), ctx.Bool("offline"))
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	matches, err := matchInstances(instances, query)
+	if err != nil {
+		return err
+	}
+
+	var result *sessionResult
+	switch {
+	case len(matches) == 0:
+		return fmt.Errorf("no running instance matches %q", query)
+
+	case len(matches) == 1:
+		inst := matches[0]
+		if appConfig.Protected(inst.Environment) && !ctx.Bool("yes") {
+			if err := confirmProtected(inst); err != nil {
+				return err
+			}
+		}
+		m := model{overrides: overrides, instances: instances, command: command}
+		m.selectTarget(inst)
+		if len(command) > 0 && m.transport == config.TransportSSM {
+			// An SSM shell cannot run a command, ssh over SSM can
+			m.transport = config.TransportSSHSSM
+		}
+		session := runSession(m)
+		if err := recordSession(session); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+		result = &session
+
+	default:
+		if !term.IsTerminal(int(os.Stdin.Fd())) || !term.IsTerminal(int(os.Stdout.Fd())) {
+			var names []string
+			for _, inst := range matches {
+				names = append(names, fmt.Sprintf("%s (%s)", instanceLabel(inst), inst.ID))
+			}
+			return fmt.Errorf("%d running instances match %q: %s", len(matches), query, strings.Join(names, ", "))
+		}
+
+		m, err := initialModel(overrides, ctx.String("filter"), 0, ctx.Bool("offline"))
+		if err != nil {
+			return err
+		}
+		if m.loading {
+			m.lastUpdate = time.Now()
+		}
+		m.instances = instances
+		m.loading = false
+		m.refreshing = false
+		m.command = command
+		m.matchQuery = query
+		m.matches = make(map[string]bool)
+		for _, inst := range matches {
+			m.matches[inst.ID] = true
+		}
+		m.filterInstances()
+
+		// A remote command runs once, on the instance picked; the exit
+		// status of a session is only passed on when the browser ends with it
+		loop := sessionLoop(ctx) && len(command) == 0
+		if result, err = browse(m, loop); result == nil || loop {
+			return err
+		}
+	}
+
+	switch {
+	case result.exitCode > 0:
+		return cli.Exit("", result.exitCode)
+	case result.exitCode < 0:
+		return result.err
+	}
+	return nil
+}
+
+// sshCommand returns the remote command given after the query, without the
+// "--" separating it. Flags are only parsed before the query, so one after
+// it is refused rather than run as the command.
+func sshCommand(args []string) ([]string, error) {
+	switch {
+	case len(args) == 0:
+		return nil, nil
+	case args[0] == "--":
+		return args[1:], nil
+	case strings.HasPrefix(args[0], "-"):
+		return nil, fmt.Errorf("%s after the query: flags go before it, and a command starting with - after -- (relocate ssh [flags] <query> [-- command...])", args[0])
+	}
+	return args, nil
+}
+
+// confirmProtected asks for the instance name to be typed before connecting
+// to an instance in a protected environment, as instance actions there do
+func confirmProtected(inst EC2Instance) error {
+	label := instanceLabel(inst)
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return fmt.Errorf("%s is in the protected %s environment (pass --yes to connect without confirming)", label, inst.Environment)
+	}
+	fmt.Fprintf(os.Stderr, "%s (%s) is in the protected %s environment. Type %s to connect: ", label, inst.ID, inst.Environment, label)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if strings.TrimSpace(answer) != label {
+		return fmt.Errorf("not connecting to %s", label)
+	}
+	return nil
+}
@@ -0,0 +1,286 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// Markers around the part of the managed file relocate ssh-config writes
+const (
+	sshConfigBegin = "# BEGIN relocate (generated by relocate ssh-config; changes between these markers are overwritten)"
+	sshConfigEnd   = "# END relocate"
+)
+
+// errSSHConfigDrift reports a managed file that differs from the inventory
+var errSSHConfigDrift = errors.New("ssh config is out of date (run relocate ssh-config to update it)")
+
+// Reasons an instance gets no Host block
+var (
+	errNoAddress        = errors.New("no address to connect to")
+	errEphemeralKeyAuth = errors.New("auth is instance-connect, whose keys are too short-lived for an ssh config")
+)
+
+// defaultSSHConfigPath is the managed include file
+func defaultSSHConfigPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".ssh", "config.d", "relocate"), nil
+}
+
+// sshHost is the Host block of one instance
+type sshHost struct {
+	alias string
+	inst  EC2Instance
+	lines []string // "Keyword value", in order
+}
+
+func (h sshHost) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Host %s\n", h.alias)
+	for _, line := range h.lines {
+		b.WriteString("  " + line + "\n")
+	}
+	return b.String()
+}
+
+// sshHostBlock resolves the Host block connecting to target as relocate
+// would. It fails if the instance has no address to connect to, or is
+// authenticated with an ephemeral EC2 Instance Connect key.
+func sshHostBlock(target remoteTarget) ([]string, error) {
+	inst, conn := target.inst, target.conn
+	if conn.Auth == config.AuthInstanceConnect && target.transport != config.TransportSSM {
+		return nil, errEphemeralKeyAuth
+	}
+	lines := []string{fmt.Sprintf("# %s %s %s/%s", inst.ID, inst.Environment, inst.Profile, inst.Region)}
+
+	if config.DirectTransport(target.transport) {
+		kind := conn.Address
+		if kind == "" {
+			kind, _ = preferredAddress(inst, conn.Addresses)
+		}
+		host := inst.Address(kind)
+		if host == "" {
+			return nil, errNoAddress
+		}
+		lines = append(lines, "HostName "+host)
+	} else {
+		// An SSM shell has no ssh equivalent; ssh goes through SSM instead
+		lines = append(lines, "HostName "+inst.ID, "ProxyCommand "+ssmProxyCommand(inst))
+	}
+	lines = append(lines, "User "+conn.User, "Port "+strconv.Itoa(conn.Port))
+	if conn.KeyPath != "" {
+		keyPath := conn.KeyPath
+		if strings.ContainsAny(keyPath, " \t") {
+			keyPath = `"` + keyPath + `"`
+		}
+		lines = append(lines, "IdentityFile "+keyPath)
+	}
+	if conn.ProxyJump != "" {
+		lines = append(lines, "ProxyCommand "+jumpProxyCommand(conn))
+	}
+	for _, opt := range conn.Options {
+		// -o Key=Value is written Key Value in a config file
+		if key, value, ok := strings.Cut(opt, "="); ok {
+			opt = key + " " + value
+		}
+		lines = append(lines, opt)
+	}
+	return lines, nil
+}
+
+var unsafeAliasChars = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// sshAlias turns a Name tag into a Host alias, or the instance ID if none
+// is left
+func sshAlias(inst EC2Instance) string {
+	alias := strings.Trim(unsafeAliasChars.ReplaceAllString(inst.Name, "-"), "-.")
+	if alias == "" {
+		return inst.ID
+	}
+	return alias
+}
+
+// skippedHost is an instance left out of the ssh config, and why
+type skippedHost struct {
+	inst EC2Instance
+	err  error
+}
+
+// sshHosts resolves a Host block for every instance. Instances sharing an
+// alias all get their ID appended to it, so that aliases do not depend on
+// the order instances are discovered in.
+func sshHosts(m model, instances []EC2Instance) (hosts []sshHost, skipped []skippedHost) {
+	count := make(map[string]int)
+	for _, inst := range instances {
+		count[sshAlias(inst)]++
+	}
+	for _, inst := range instances {
+		lines, err := sshHostBlock(m.resolveTarget(inst))
+		if err != nil {
+			skipped = append(skipped, skippedHost{inst: inst, err: err})
+			continue
+		}
+		alias := sshAlias(inst)
+		if count[alias] > 1 && alias != inst.ID {
+			alias += "-" + inst.ID
+		}
+		hosts = append(hosts, sshHost{alias: alias, inst: inst, lines: lines})
+	}
+	slices.SortFunc(hosts, func(a, b sshHost) int { return strings.Compare(a.alias, b.alias) })
+	return hosts, skipped
+}
+
+// renderSSHConfig renders the managed section, markers included
+func renderSSHConfig(hosts []sshHost) string {
+	var blocks []string
+	for _, host := range hosts {
+		blocks = append(blocks, host.String())
+	}
+	return sshConfigBegin + "\n\n" + strings.Join(blocks, "\n") + "\n" + sshConfigEnd + "\n"
+}
+
+// splitManaged splits a file around its managed section. found is false if
+// the file has no complete section.
+func splitManaged(content string) (before, section, after string, found bool) {
+	start := strings.Index(content, sshConfigBegin)
+	if start < 0 {
+		return content, "", "", false
+	}
+	end := strings.Index(content[start:], sshConfigEnd+"\n")
+	if end < 0 {
+		return content, "", "", false
+	}
+	end += start + len(sshConfigEnd) + 1
+	return content[:start], content[start:end], content[end:], true
+}
+
+// managedHosts maps the aliases of a managed section to their blocks
+func managedHosts(section string) map[string]string {
+	hosts := make(map[string]string)
+	var alias string
+	for _, line := range strings.Split(section, "\n") {
+		switch {
+		case strings.HasPrefix(line, "Host "):
+			alias = strings.TrimPrefix(line, "Host ")
+			hosts[alias] = line + "\n"
+		case strings.HasPrefix(line, "  ") && alias != "":
+			hosts[alias] += line + "\n"
+		default:
+			alias = ""
+		}
+	}
+	return hosts
+}
+
+// runSSHConfig writes Host blocks for the inventory to the managed section
+// of path, keeping the rest of the file. With check it only reports how the
+// section differs from the inventory, failing if it does; with stdout it
+// writes the section to stdout instead.
+func runSSHConfig(overrides config.Overrides, filterTag string, offline bool, path string, allStates, check, stdout bool) error {
+	instances, warnings, err := fetchInventory(overrides, filterTag, offline)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	if !allStates {
+		instances = slices.DeleteFunc(instances, func(inst EC2Instance) bool { return inst.State != "running" })
+	}
+	hosts, skipped := sshHosts(model{overrides: overrides, instances: instances}, instances)
+	for _, skip := range skipped {
+		fmt.Fprintf(os.Stderr, "Warning: skipping %s (%s): %v\n", instanceLabel(skip.inst), skip.inst.ID, skip.err)
+	}
+	section := renderSSHConfig(hosts)
+	if stdout {
+		fmt.Print(section)
+		return nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	before, current, after, found := splitManaged(string(data))
+
+	if check {
+		if current == section {
+			fmt.Printf("%s is up to date (%d hosts)\n", path, len(hosts))
+			return nil
+		}
+		old := managedHosts(current)
+		for _, host := range hosts {
+			block, ok := old[host.alias]
+			switch {
+			case !ok:
+				fmt.Printf("+ %s (%s)\n", host.alias, host.inst.ID)
+			case block != host.String():
+				fmt.Printf("~ %s (%s)\n", host.alias, host.inst.ID)
+			}
+			delete(old, host.alias)
+		}
+		for _, alias := range slices.Sorted(maps.Keys(old)) {
+			fmt.Printf("- %s\n", alias)
+		}
+		return errSSHConfigDrift
+	}
+
+	if !found && before != "" && !strings.HasSuffix(before, "\n") {
+		before += "\n"
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(before+section+after), 0o600); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote %d hosts to %s\n", len(hosts), path)
+
+	if defaultPath, err := defaultSSHConfigPath(); err == nil && path == defaultPath && !sshConfigIncludes(path) {
+		fmt.Printf("Add this line near the top of ~/.ssh/config to use them:\n\n    Include %s\n", path)
+	}
+	return nil
+}
+
+// sshConfigIncludes reports whether ~/.ssh/config includes path, going by
+// its Include lines
+func sshConfigIncludes(path string) bool {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return false
+	}
+	data, err := os.ReadFile(filepath.Join(home, ".ssh", "config"))
+	if err != nil {
+		return false
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.EqualFold(fields[0], "Include") {
+			continue
+		}
+		for _, pattern := range fields[1:] {
+			// Relative patterns are relative to ~/.ssh
+			pattern = strings.Trim(pattern, `"`)
+			if strings.HasPrefix(pattern, "~/") {
+				pattern = filepath.Join(home, pattern[2:])
+			} else if !filepath.IsAbs(pattern) {
+				pattern = filepath.Join(home, ".ssh", pattern)
+			}
+			if ok, _ := filepath.Match(pattern, path); ok {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"errors"
+	"maps"
+	"slices"
+	"testing"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+func TestSSHHosts(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	instances := []EC2Instance{
+		{ID: "i-2", Name: "web 1", PrivateIP: "10.0.0.2"},
+		{ID: "i-1", Name: "web 1", PrivateIP: "10.0.0.1"},
+		{ID: "i-3", Name: "api/v2", PublicIP: "203.0.113.3"},
+		{ID: "i-4", Name: "--", PrivateIP: "10.0.0.4"},
+		{ID: "i-5", Name: "db"},
+	}
+	hosts, skipped := sshHosts(model{instances: instances}, instances)
+
+	var aliases []string
+	for _, host := range hosts {
+		aliases = append(aliases, host.alias)
+	}
+	want := []string{"api-v2", "i-4", "web-1-i-1", "web-1-i-2"}
+	if !slices.Equal(aliases, want) {
+		t.Errorf("aliases = %q, want %q", aliases, want)
+	}
+	if len(skipped) != 1 || skipped[0].inst.ID != "i-5" || !errors.Is(skipped[0].err, errNoAddress) {
+		t.Errorf("skipped = %+v, want i-5 for having no address", skipped)
+	}
+}
+
+func TestSSHHostBlockInstanceConnect(t *testing.T) {
+	inst := EC2Instance{ID: "i-1", PrivateIP: "10.0.0.1"}
+	conn := config.Connection{User: "ec2-user", Port: 22, KeyPath: "/keys/web.pem", Auth: config.AuthInstanceConnect, Addresses: config.DefaultAddressPreference}
+
+	tests := []struct {
+		transport string
+		wantErr   error
+	}{
+		{transport: config.TransportSSH, wantErr: errEphemeralKeyAuth},
+		{transport: config.TransportSSHSSM, wantErr: errEphemeralKeyAuth},
+		{transport: config.TransportSSM},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.transport, func(t *testing.T) {
+			_, err := sshHostBlock(remoteTarget{inst: inst, conn: conn, transport: tt.transport})
+			if !errors.Is(err, tt.wantErr) {
+				t.Errorf("sshHostBlock() error = %v, want %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestSplitManaged(t *testing.T) {
+	section := sshConfigBegin + "\n\nHost web\n  HostName 10.0.0.1\n\n" + sshConfigEnd + "\n"
+
+	tests := []struct {
+		name                 string
+		content              string
+		before, inner, after string
+		found                bool
+	}{
+		{
+			name: "empty",
+		},
+		{
+			name:    "no section",
+			content: "Host *\n  ServerAliveInterval 30\n",
+			before:  "Host *\n  ServerAliveInterval 30\n",
+		},
+		{
+			name:    "section only",
+			content: section,
+			inner:   section,
+			found:   true,
+		},
+		{
+			name:    "section between hosts",
+			content: "Host a\n  User a\n" + section + "Host b\n  User b\n",
+			before:  "Host a\n  User a\n",
+			inner:   section,
+			after:   "Host b\n  User b\n",
+			found:   true,
+		},
+		{
+			name:    "missing end marker",
+			content: "Host a\n" + sshConfigBegin + "\nHost web\n",
+			before:  "Host a\n" + sshConfigBegin + "\nHost web\n",
+		},
+		{
+			name:    "end marker without newline",
+			content: section[:len(section)-1],
+			before:  section[:len(section)-1],
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			before, inner, after, found := splitManaged(tt.content)
+			if before != tt.before || inner != tt.inner || after != tt.after || found != tt.found {
+				t.Errorf("splitManaged() = %q, %q, %q, %v, want %q, %q, %q, %v",
+					before, inner, after, found, tt.before, tt.inner, tt.after, tt.found)
+			}
+		})
+	}
+}
+
+func TestManagedHosts(t *testing.T) {
+	web := sshHost{alias: "web", lines: []string{"# i-1 prod dev/us-east-1", "HostName 10.0.0.1", "User ec2-user"}}
+	db := sshHost{alias: "db-i-2", lines: []string{"HostName 10.0.0.2"}}
+
+	got := managedHosts(renderSSHConfig([]sshHost{db, web}))
+	want := map[string]string{"db-i-2": db.String(), "web": web.String()}
+	if !maps.Equal(got, want) {
+		t.Errorf("managedHosts() = %q, want %q", got, want)
+	}
+}
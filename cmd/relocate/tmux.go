@@ -0,0 +1,190 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// errNoTmux is returned when tmux is not installed
+var errNoTmux = errors.New("tmux is not installed (connecting to several instances at once opens one tmux pane or window per instance)")
+
+// tmuxOpenedMsg reports the result of opening instances in tmux. session is
+// the new tmux session to attach to, or "" when the panes were opened in the
+// tmux session relocate runs in.
+type tmuxOpenedMsg struct {
+	session string
+	count   int
+	err     error
+}
+
+// insideTmux reports whether relocate runs inside a tmux session
+func insideTmux() bool {
+	return os.Getenv("TMUX") != ""
+}
+
+// tmuxAvailable reports whether tmux is installed
+func tmuxAvailable() bool {
+	_, err := exec.LookPath("tmux")
+	return err == nil
+}
+
+// shellQuote quotes s for a POSIX shell
+func shellQuote(s string) string {
+	if s != "" && strings.IndexFunc(s, func(r rune) bool {
+		return !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("@%+=:,./_-", r))
+	}) < 0 {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// paneCommand returns the shell command that opens a session on target in a
+// tmux pane. An ephemeral EC2 Instance Connect key is pushed right away and
+// removed by the pane once the session ends.
+func paneCommand(target remoteTarget) (string, error) {
+	conn := target.conn
+	var key *ephemeralKey
+	if conn.Auth == config.AuthInstanceConnect && target.transport != config.TransportSSM {
+		var err error
+		key, err = useInstanceConnect(target.inst, &conn)
+		if err != nil && conn.KeyPath == "" {
+			return "", err
+		}
+	}
+
+	name, args, err := connectArgs(target.inst, conn, target.transport)
+	if err != nil {
+		if key != nil {
+			key.Remove()
+		}
+		return "", err
+	}
+
+	words := []string{shellQuote(name)}
+	for _, arg := range args {
+		words = append(words, shellQuote(arg))
+	}
+	command := strings.Join(words, " ")
+	if key != nil {
+		command += "; rm -rf " + shellQuote(key.dir)
+	}
+	return command, nil
+}
+
+// tmux runs a tmux command and returns its trimmed output
+func tmux(args ...string) (string, error) {
+	out, err := exec.Command("tmux", args...).Output()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) && len(exitErr.Stderr) > 0 {
+		return "", fmt.Errorf("tmux %s: %s", args[0], strings.TrimSpace(string(exitErr.Stderr)))
+	}
+	return strings.TrimSpace(string(out)), err
+}
+
+// openTmux returns a command that opens a session on every target in tmux:
+// in a new window of the current tmux session when relocate runs inside
+// tmux, otherwise in a new detached session to attach to. With the tiled
+// layout every instance gets a pane of one window, optionally with
+// synchronized input; with the windows layout every instance gets a window.
+// A new session is sized width x height, the size of the terminal.
+func openTmux(targets []remoteTarget, layout string, synchronize bool, width, height int) tea.Cmd {
+	return func() tea.Msg {
+		session := ""
+		if !insideTmux() {
+			session = fmt.Sprintf("relocate-%s", time.Now().Format("150405"))
+			if len(targets) == 1 {
+				session = fmt.Sprintf("relocate-%s-%s", targets[0].inst.ID, time.Now().Format("150405"))
+			}
+		}
+		err := openTmuxPanes(targets, layout, synchronize, session, width, height)
+		if err != nil && session != "" {
+			tmux("kill-session", "-t", session)
+		}
+		return tmuxOpenedMsg{session: session, count: len(targets), err: err}
+	}
+}
+
+// openTmuxPanes opens the panes or windows of openTmux, creating session
+// first unless it is ""
+func openTmuxPanes(targets []remoteTarget, layout string, synchronize bool, session string, width, height int) error {
+	var window string
+	for i, target := range targets {
+		command, err := paneCommand(target)
+		if err != nil {
+			return fmt.Errorf("%s: %w", instanceLabel(target.inst), err)
+		}
+		name := tmuxName(target.inst)
+
+		var args []string
+		switch {
+		case i == 0 && session != "":
+			args = []string{"new-session", "-d", "-s", session, "-n", name, "-x", strconv.Itoa(width), "-y", strconv.Itoa(height)}
+		case i == 0 || layout == config.TmuxWindows:
+			args = []string{"new-window", "-n", name}
+			if session != "" {
+				args = append(args, "-t", session+":")
+			}
+		default:
+			args = []string{"split-window", "-t", window}
+		}
+		args = append(args, "-P", "-F", "#{window_id} #{pane_id}", command)
+
+		out, err := tmux(args...)
+		if err != nil {
+			return err
+		}
+		ids := strings.Fields(out)
+		if len(ids) != 2 {
+			return fmt.Errorf("tmux %s: unexpected output %q", args[0], out)
+		}
+		if i == 0 {
+			window = ids[0]
+		}
+		// Pane titles are cosmetic; older tmux versions lack them
+		tmux("select-pane", "-t", ids[1], "-T", name)
+		if layout == config.TmuxTiled && i > 0 {
+			// Re-tile after every split so that there is room for the next one
+			if _, err := tmux("select-layout", "-t", window, "tiled"); err != nil {
+				return err
+			}
+		}
+	}
+
+	if layout == config.TmuxTiled {
+		tmux("set-window-option", "-t", window, "pane-border-status", "top")
+		if synchronize {
+			if _, err := tmux("set-window-option", "-t", window, "synchronize-panes", "on"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tmuxName names the window or pane of inst. The ID tells apart instances
+// sharing a Name tag, e.g. in an auto scaling group.
+func tmuxName(inst EC2Instance) string {
+	if inst.Name == "" {
+		return inst.ID
+	}
+	return inst.Name + " (" + inst.ID + ")"
+}
+
+// attachTmux attaches the terminal to a tmux session until it is detached
+// or ends
+func attachTmux(session string) error {
+	cmd := exec.Command("tmux", "attach-session", "-t", session)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
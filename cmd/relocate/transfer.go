@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/term"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// transferProgress counts the bytes and files of a transfer as it runs
+type transferProgress struct {
+	total atomic.Int64 // bytes to transfer; grows file by file on downloads
+	done  atomic.Int64
+	files atomic.Int64
+
+	mu      sync.Mutex
+	current string // file being transferred
+}
+
+func (p *transferProgress) setCurrent(name string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.current = name
+}
+
+func (p *transferProgress) currentFile() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.current
+}
+
+// transferRun is a file transfer set up and run from the browser
+type transferRun struct {
+	inst     EC2Instance
+	upload   bool
+	local    string
+	remote   string
+	field    int // focused field of the dialog: 0 direction, 1 local path, 2 remote path
+	running  bool
+	started  time.Time
+	progress *transferProgress
+	cancel   context.CancelFunc
+}
+
+// transferDoneMsg reports the end of a transfer started from the browser
+type transferDoneMsg struct {
+	err error
+}
+
+// transfer copies local to remote on target when upload is set, otherwise
+// remote to local. Directories are copied recursively only when recursive is
+// set. A download needs a remote path; an upload defaults to the home
+// directory.
+func transfer(ctx context.Context, target remoteTarget, upload bool, local, remote string, recursive, interactive bool, progress *transferProgress) error {
+	if !upload && remote == "" {
+		return errors.New("a download needs a remote path (use ~ for the home directory)")
+	}
+	if local == "~" || strings.HasPrefix(local, "~/") {
+		// Paths typed in the browser are not expanded by a shell
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return err
+		}
+		local = filepath.Join(home, local[1:])
+	}
+
+	dialer, client, err := dialNative(target, interactive)
+	if err != nil {
+		return err
+	}
+	defer dialer.Close()
+	defer client.Close()
+	stop := context.AfterFunc(ctx, func() { client.Close() })
+	defer stop()
+
+	if upload {
+		err = scpUpload(client, local, remote, recursive, progress)
+	} else {
+		err = scpDownload(client, remote, local, recursive, progress)
+	}
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+	return err
+}
+
+// startTransfer returns a command that runs a transfer from the browser,
+// where nothing can be prompted for. Directories are always copied
+// recursively.
+func startTransfer(ctx context.Context, target remoteTarget, run transferRun) tea.Cmd {
+	return func() tea.Msg {
+		return transferDoneMsg{err: transfer(ctx, target, run.upload, run.local, run.remote, true, false, run.progress)}
+	}
+}
+
+// remoteShellPath quotes a remote path for the shell that runs scp on the
+// instance, leaving a leading ~ to be expanded. An empty path is the home
+// directory.
+func remoteShellPath(path string) string {
+	switch {
+	case path == "", path == "~":
+		return "."
+	case strings.HasPrefix(path, "~/"):
+		return "~/" + shellQuote(path[2:])
+	}
+	return shellQuote(path)
+}
+
+// scpSession starts scp on the instance in sink (-t) or source (-f) mode
+func scpSession(client *ssh.Client, mode, path string, recursive bool) (*ssh.Session, io.WriteCloser, *bufio.Reader, *strings.Builder, error) {
+	session, err := client.NewSession()
+	if err != nil {
+		return nil, nil, nil, nil, err
+	}
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, nil, nil, err
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		session.Close()
+		return nil, nil, nil, nil, err
+	}
+	stderr := new(strings.Builder)
+	session.Stderr = stderr
+
+	command := "scp " + mode
+	if recursive {
+		command = "scp -r " + mode
+	}
+	if err := session.Start(command + " " + remoteShellPath(path)); err != nil {
+		session.Close()
+		return nil, nil, nil, nil, err
+	}
+	return session, stdin, bufio.NewReader(stdout), stderr, nil
+}
+
+// scpError adds what scp printed on the instance to an error
+func scpError(err error, stderr *strings.Builder) error {
+	if msg := strings.TrimSpace(stderr.String()); msg != "" {
+		return fmt.Errorf("%w: %s", err, msg)
+	}
+	return err
+}
+
+// scpAck reads scp's answer to the last message: 0 for success, or 1 or 2
+// followed by an error message
+func scpAck(r *bufio.Reader) error {
+	b, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	switch b {
+	case 0:
+		return nil
+	case 1, 2:
+		msg, _ := r.ReadString('\n')
+		return errors.New(strings.TrimSpace(msg))
+	}
+	return fmt.Errorf("unexpected scp response %q", b)
+}
+
+// scpUpload sends a local file, or directory when recursive, to path on the
+// instance
+func scpUpload(client *ssh.Client, local, path string, recursive bool, progress *transferProgress) error {
+	info, err := os.Stat(local)
+	if err != nil {
+		return err
+	}
+	if info.IsDir() && !recursive {
+		return fmt.Errorf("%s is a directory (use -r)", local)
+	}
+	if err := countUpload(local, info, progress); err != nil {
+		return err
+	}
+
+	session, w, r, stderr, err := scpSession(client, "-t", path, info.IsDir())
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	if err := scpAck(r); err != nil {
+		return scpError(err, stderr)
+	}
+	if info.IsDir() {
+		err = scpSendDir(w, r, local, info, progress)
+	} else {
+		err = scpSendFile(w, r, local, info, progress)
+	}
+	if err != nil {
+		return scpError(err, stderr)
+	}
+	w.Close()
+	if err := session.Wait(); err != nil {
+		return scpError(err, stderr)
+	}
+	return nil
+}
+
+// countUpload adds up the size of the files an upload sends
+func countUpload(local string, info fs.FileInfo, progress *transferProgress) error {
+	if !info.IsDir() {
+		progress.total.Add(info.Size())
+		return nil
+	}
+	return filepath.WalkDir(local, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if info, err := os.Stat(path); err == nil && info.Mode().IsRegular() {
+			progress.total.Add(info.Size())
+		}
+		return nil
+	})
+}
+
+// scpSendFile sends one regular file
+func scpSendFile(w io.Writer, r *bufio.Reader, path string, info fs.FileInfo, progress *transferProgress) error {
+	if strings.ContainsAny(info.Name(), "\n") {
+		return fmt.Errorf("%s: file names with newlines cannot be copied", path)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	progress.setCurrent(info.Name())
+	if _, err := fmt.Fprintf(w, "C%04o %d %s\n", info.Mode().Perm(), info.Size(), info.Name()); err != nil {
+		return err
+	}
+	if err := scpAck(r); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	n, err := io.Copy(countingWriter{w, &progress.done}, io.LimitReader(f, info.Size()))
+	if err != nil {
+		return err
+	}
+	if n != info.Size() {
+		return fmt.Errorf("%s: file shrank while it was copied", path)
+	}
+	if _, err := w.Write([]byte{0}); err != nil {
+		return err
+	}
+	if err := scpAck(r); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	progress.files.Add(1)
+	return nil
+}
+
+// scpSendDir sends a directory and everything in it. Symbolic links are
+// followed; other special files are skipped.
+func scpSendDir(w io.Writer, r *bufio.Reader, path string, info fs.FileInfo, progress *transferProgress) error {
+	if _, err := fmt.Fprintf(w, "D%04o 0 %s\n", info.Mode().Perm(), info.Name()); err != nil {
+		return err
+	}
+	if err := scpAck(r); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		child := filepath.Join(path, entry.Name())
+		info, err := os.Stat(child)
+		if err != nil {
+			return err
+		}
+		switch {
+		case info.IsDir():
+			err = scpSendDir(w, r, child, info, progress)
+		case info.Mode().IsRegular():
+			err = scpSendFile(w, r, child, info, progress)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if _, err := fmt.Fprint(w, "E\n"); err != nil {
+		return err
+	}
+	return scpAck(r)
+}
+
+// scpDownload receives path, a file or directory when recursive, from the
+// instance into local. An existing local directory receives it under its
+// own name; otherwise it is saved as local.
+func scpDownload(client *ssh.Client, path, local string, recursive bool, progress *transferProgress) error {
+	session, w, r, stderr, err := scpSession(client, "-f", path, recursive)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	var dirs []string // directories being received, innermost last
+	into := false     // local is an existing directory
+	if info, err := os.Stat(local); err == nil && info.IsDir() {
+		into = true
+	}
+	destination := func(name string) (string, error) {
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+			return "", fmt.Errorf("scp sent an invalid file name %q", name)
+		}
+		switch {
+		case len(dirs) > 0:
+			return filepath.Join(dirs[len(dirs)-1], name), nil
+		case into:
+			return filepath.Join(local, name), nil
+		}
+		return local, nil
+	}
+
+	received := false
+	if _, err := w.Write([]byte{0}); err != nil {
+		return scpError(err, stderr)
+	}
+	for {
+		line, err := r.ReadString('\n')
+		if errors.Is(err, io.EOF) && line == "" {
+			break
+		}
+		if err != nil {
+			return scpError(err, stderr)
+		}
+		line = strings.TrimSuffix(line, "\n")
+		if line == "" {
+			return errors.New("unexpected empty scp message")
+		}
+
+		switch line[0] {
+		case 1, 2:
+			return errors.New(strings.TrimSpace(line[1:]))
+
+		case 'T':
+			// Modification times are not kept
+
+		case 'C', 'D':
+			mode, size, name, err := parseSCPHeader(line)
+			if err != nil {
+				return err
+			}
+			dest, err := destination(name)
+			if err != nil {
+				return err
+			}
+			if line[0] == 'D' {
+				if err := os.MkdirAll(dest, mode|0o700); err != nil {
+					return err
+				}
+				dirs = append(dirs, dest)
+				break
+			}
+			if _, err := w.Write([]byte{0}); err != nil {
+				return err
+			}
+			if err := scpReceiveFile(r, dest, mode, size, progress); err != nil {
+				return err
+			}
+			if err := scpAck(r); err != nil {
+				return fmt.Errorf("%s: %w", dest, err)
+			}
+			received = true
+
+		case 'E':
+			if len(dirs) == 0 {
+				return errors.New("scp ended a directory that was not started")
+			}
+			dirs = dirs[:len(dirs)-1]
+			received = true
+
+		default:
+			return fmt.Errorf("unexpected scp message %q", line)
+		}
+		if _, err := w.Write([]byte{0}); err != nil {
+			return err
+		}
+	}
+
+	if err := session.Wait(); err != nil {
+		return scpError(err, stderr)
+	}
+	if !received {
+		return scpError(fmt.Errorf("%s: nothing was received", path), stderr)
+	}
+	return nil
+}
+
+// parseSCPHeader parses a "Cmode size name" or "Dmode 0 name" message
+func parseSCPHeader(line string) (os.FileMode, int64, string, error) {
+	fields := strings.SplitN(line[1:], " ", 3)
+	if len(fields) != 3 {
+		return 0, 0, "", fmt.Errorf("unexpected scp message %q", line)
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return 0, 0, "", fmt.Errorf("unexpected scp message %q", line)
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil || size < 0 {
+		return 0, 0, "", fmt.Errorf("unexpected scp message %q", line)
+	}
+	return os.FileMode(mode).Perm(), size, fields[2], nil
+}
+
+// scpReceiveFile writes size bytes read from r to path
+func scpReceiveFile(r io.Reader, path string, mode os.FileMode, size int64, progress *transferProgress) error {
+	progress.setCurrent(filepath.Base(path))
+	progress.total.Add(size)
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.CopyN(countingWriter{f, &progress.done}, r, size); err != nil {
+		f.Close()
+		return err
+	}
+	progress.files.Add(1)
+	return f.Close()
+}
+
+// transferEndpoint is a cp argument: a local path, or a path on the
+// instance with the given ID or Name tag
+type transferEndpoint struct {
+	instance string // "" for a local path
+	path     string
+}
+
+// parseEndpoint parses a cp argument. As with scp, an argument is remote
+// when it has a colon before any slash: name-or-id:/path.
+func parseEndpoint(arg string) transferEndpoint {
+	i := strings.Index(arg, ":")
+	if i <= 0 || strings.ContainsAny(arg[:i], `/\`) || filepath.VolumeName(arg) != "" {
+		return transferEndpoint{path: arg}
+	}
+	return transferEndpoint{instance: arg[:i], path: arg[i+1:]}
+}
+
+// runCopy copies between this machine and an instance, one side of
+// source and destination being name-or-id:/path, with a progress line on
+// the terminal
+func runCopy(overrides config.Overrides, filterTag string, offline bool, source, destination string, recursive bool) error {
+	from, to := parseEndpoint(source), parseEndpoint(destination)
+	switch {
+	case from.instance != "" && to.instance != "":
+		return errors.New("copying between two instances is not supported")
+	case from.instance == "" && to.instance == "":
+		return errors.New("one of the source and destination must be name-or-id:/path")
+	}
+	upload := to.instance != ""
+	remote, local := from, to
+	if upload {
+		remote, local = to, from
+	}
+
+	instances, warnings, err := fetchInventory(overrides, filterTag, offline)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	inst, err := findInstance(instances, remote.instance)
+	if err != nil {
+		return err
+	}
+	target := model{overrides: overrides, instances: instances}.resolveTarget(inst)
+
+	progress := &transferProgress{}
+	started := time.Now()
+	done := make(chan struct{})
+	var wg sync.WaitGroup
+	if term.IsTerminal(int(os.Stderr.Fd())) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			ticker := time.NewTicker(200 * time.Millisecond)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-ticker.C:
+					fmt.Fprintf(os.Stderr, "\r\033[K%s", progressLine(progress, started))
+				case <-done:
+					fmt.Fprintf(os.Stderr, "\r\033[K")
+					return
+				}
+			}
+		}()
+	}
+	err = transfer(context.Background(), target, upload, local.path, remote.path, recursive, true, progress)
+	close(done)
+	wg.Wait()
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("Copied %d files, %s in %s\n", progress.files.Load(), formatBytes(progress.done.Load()), time.Since(started).Round(100*time.Millisecond))
+	return nil
+}
+
+// progressLine summarizes a running transfer on one line
+func progressLine(progress *transferProgress, started time.Time) string {
+	done, total := progress.done.Load(), progress.total.Load()
+	rate := float64(done) / max(time.Since(started).Seconds(), 0.001)
+	return fmt.Sprintf("%s %s %s/%s  %s/s", progressBar(done, total, 30), progress.currentFile(), formatBytes(done), formatBytes(total), formatBytes(int64(rate)))
+}
+
+// progressBar draws done out of total as a bar width cells wide followed by
+// a percentage
+func progressBar(done, total int64, width int) string {
+	ratio := 1.0
+	if total > 0 {
+		ratio = min(1, float64(done)/float64(total))
+	}
+	filled := int(ratio * float64(width))
+	return fmt.Sprintf("[%s%s] %3.0f%%", strings.Repeat("█", filled), strings.Repeat("░", width-filled), ratio*100)
+}
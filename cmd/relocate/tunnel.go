@@ -0,0 +1,463 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/urfave/cli/v2"
+	"golang.org/x/crypto/ssh"
+
+	"github.com/ghazimuharam/relocate/internal/config"
+)
+
+// tunnelDialTimeout bounds connecting to the target of a remote forward and
+// the SOCKS handshake of a dynamic one
+const tunnelDialTimeout = 15 * time.Second
+
+// tunnelLink is the SSH connection to an instance that its tunnels share.
+// It is closed when the last of them closes.
+type tunnelLink struct {
+	dialer *nativeDialer
+	client *ssh.Client
+	refs   atomic.Int32
+}
+
+// release drops a reference to the link, closing it with the last one
+func (l *tunnelLink) release() {
+	if l.refs.Add(-1) == 0 {
+		l.client.Close()
+		l.dialer.Close()
+	}
+}
+
+// tunnel is a port forward through an instance, open until it is closed or
+// the connection to the instance drops
+type tunnel struct {
+	forward  config.Forward
+	inst     EC2Instance
+	opened   time.Time
+	link     *tunnelLink
+	listener net.Listener
+
+	sent     atomic.Int64 // bytes from this side to the instance side
+	received atomic.Int64 // bytes from the instance side to this side
+	total    atomic.Int64 // forwarded connections
+	active   atomic.Int64 // forwarded connections still open
+
+	mu     sync.Mutex
+	conns  map[net.Conn]bool // forwarded connections still open, both ends
+	closed bool
+	err    error // why the tunnel closed by itself
+	done   chan struct{}
+}
+
+// tunnelOpenedMsg reports the result of opening a tunnel from the browser
+type tunnelOpenedMsg struct {
+	tunnel *tunnel
+	err    error
+}
+
+// openTunnels connects to target with the built-in SSH client and sets up
+// every forward through that one connection
+func openTunnels(target remoteTarget, forwards []config.Forward, interactive bool) ([]*tunnel, error) {
+	dialer, client, err := dialNative(target, interactive)
+	if err != nil {
+		return nil, err
+	}
+	link := &tunnelLink{dialer: dialer, client: client}
+	link.refs.Store(1)
+	defer link.release()
+
+	var tunnels []*tunnel
+	for _, forward := range forwards {
+		t, err := link.open(target.inst, forward)
+		if err != nil {
+			for _, t := range tunnels {
+				t.Close()
+			}
+			return nil, err
+		}
+		tunnels = append(tunnels, t)
+	}
+	return tunnels, nil
+}
+
+// openTunnel returns a command that opens a tunnel from the browser, where
+// nothing can be prompted for
+func openTunnel(target remoteTarget, forward config.Forward) tea.Cmd {
+	return func() tea.Msg {
+		tunnels, err := openTunnels(target, []config.Forward{forward}, false)
+		if err != nil {
+			return tunnelOpenedMsg{err: err}
+		}
+		return tunnelOpenedMsg{tunnel: tunnels[0]}
+	}
+}
+
+// open starts listening for a forward and serving its connections
+func (l *tunnelLink) open(inst EC2Instance, forward config.Forward) (*tunnel, error) {
+	var listener net.Listener
+	var err error
+	if forward.Kind() == config.ForwardRemote {
+		listener, err = l.client.Listen("tcp", forward.ListenAddress())
+	} else {
+		listener, err = net.Listen("tcp", forward.ListenAddress())
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", forward, err)
+	}
+
+	l.refs.Add(1)
+	t := &tunnel{
+		forward:  forward,
+		inst:     inst,
+		opened:   time.Now(),
+		link:     l,
+		listener: listener,
+		conns:    make(map[net.Conn]bool),
+		done:     make(chan struct{}),
+	}
+	go t.serve()
+	go func() {
+		l.client.Wait()
+		t.shut(errors.New("connection to the instance closed"))
+	}()
+	return t, nil
+}
+
+// Close closes the tunnel and every connection forwarded through it
+func (t *tunnel) Close() {
+	t.shut(nil)
+}
+
+// shut closes the tunnel, recording err as the reason unless it is already
+// closed
+func (t *tunnel) shut(err error) {
+	t.mu.Lock()
+	if t.closed {
+		t.mu.Unlock()
+		return
+	}
+	t.closed = true
+	t.err = err
+	conns := t.conns
+	t.conns = nil
+	t.mu.Unlock()
+
+	t.listener.Close()
+	for c := range conns {
+		c.Close()
+	}
+	t.link.release()
+	close(t.done)
+}
+
+// status reports whether the tunnel is closed, and why if it closed by itself
+func (t *tunnel) status() (closed bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.closed, t.err
+}
+
+// track registers a forwarded connection, or reports false if the tunnel
+// is closed
+func (t *tunnel) track(c net.Conn) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.closed {
+		return false
+	}
+	t.conns[c] = true
+	return true
+}
+
+func (t *tunnel) untrack(c net.Conn) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.conns, c)
+}
+
+// serve accepts connections until the listener is closed
+func (t *tunnel) serve() {
+	for {
+		c, err := t.listener.Accept()
+		if err != nil {
+			t.shut(fmt.Errorf("%s: %w", t.forward, err))
+			return
+		}
+		go t.relay(c)
+	}
+}
+
+// relay forwards one accepted connection to the other end of the tunnel
+func (t *tunnel) relay(accepted net.Conn) {
+	if !t.track(accepted) {
+		accepted.Close()
+		return
+	}
+	defer t.untrack(accepted)
+	defer accepted.Close()
+
+	var other net.Conn
+	var err error
+	switch t.forward.Kind() {
+	case config.ForwardLocal:
+		other, err = t.link.client.Dial("tcp", t.forward.Target)
+	case config.ForwardRemote:
+		other, err = net.DialTimeout("tcp", t.forward.Target, tunnelDialTimeout)
+	case config.ForwardDynamic:
+		accepted.SetDeadline(time.Now().Add(tunnelDialTimeout))
+		var address string
+		if address, err = socksHandshake(accepted); err != nil {
+			return
+		}
+		if other, err = t.link.client.Dial("tcp", address); err != nil {
+			socksReply(accepted, 5) // connection refused
+			return
+		}
+		if err = socksReply(accepted, 0); err != nil {
+			other.Close()
+			return
+		}
+		accepted.SetDeadline(time.Time{})
+	}
+	if err != nil {
+		return
+	}
+	if !t.track(other) {
+		other.Close()
+		return
+	}
+	defer t.untrack(other)
+	defer other.Close()
+
+	t.total.Add(1)
+	t.active.Add(1)
+	defer t.active.Add(-1)
+
+	// For a remote forward the accepted end is on the instance
+	local, remote := accepted, other
+	if t.forward.Kind() == config.ForwardRemote {
+		local, remote = other, accepted
+	}
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		io.Copy(countingWriter{remote, &t.sent}, local)
+		closeWrite(remote)
+	}()
+	go func() {
+		defer wg.Done()
+		io.Copy(countingWriter{local, &t.received}, remote)
+		closeWrite(local)
+	}()
+	wg.Wait()
+}
+
+// countingWriter adds the bytes written through it to n
+type countingWriter struct {
+	w io.Writer
+	n *atomic.Int64
+}
+
+func (cw countingWriter) Write(p []byte) (int, error) {
+	n, err := cw.w.Write(p)
+	cw.n.Add(int64(n))
+	return n, err
+}
+
+// closeWrite signals the end of the data written to c, closing it if it
+// cannot be half-closed
+func closeWrite(c net.Conn) {
+	if hc, ok := c.(interface{ CloseWrite() error }); ok {
+		hc.CloseWrite()
+		return
+	}
+	c.Close()
+}
+
+// socksHandshake reads a SOCKS5 CONNECT request that needs no
+// authentication and returns the host:port it asks for
+func socksHandshake(c net.Conn) (string, error) {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(c, head); err != nil {
+		return "", err
+	}
+	if head[0] != 5 {
+		return "", fmt.Errorf("unsupported SOCKS version %d", head[0])
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(c, methods); err != nil {
+		return "", err
+	}
+	if !bytes.Contains(methods, []byte{0}) {
+		c.Write([]byte{5, 0xff})
+		return "", errors.New("SOCKS client requires authentication")
+	}
+	if _, err := c.Write([]byte{5, 0}); err != nil {
+		return "", err
+	}
+
+	request := make([]byte, 4)
+	if _, err := io.ReadFull(c, request); err != nil {
+		return "", err
+	}
+	if request[1] != 1 {
+		socksReply(c, 7) // command not supported
+		return "", fmt.Errorf("unsupported SOCKS command %d", request[1])
+	}
+	var host string
+	switch request[3] {
+	case 1, 4: // IPv4, IPv6
+		ip := make([]byte, 4)
+		if request[3] == 4 {
+			ip = make([]byte, 16)
+		}
+		if _, err := io.ReadFull(c, ip); err != nil {
+			return "", err
+		}
+		host = net.IP(ip).String()
+	case 3: // domain name
+		size := make([]byte, 1)
+		if _, err := io.ReadFull(c, size); err != nil {
+			return "", err
+		}
+		name := make([]byte, size[0])
+		if _, err := io.ReadFull(c, name); err != nil {
+			return "", err
+		}
+		host = string(name)
+	default:
+		socksReply(c, 8) // address type not supported
+		return "", fmt.Errorf("unsupported SOCKS address type %d", request[3])
+	}
+	port := make([]byte, 2)
+	if _, err := io.ReadFull(c, port); err != nil {
+		return "", err
+	}
+	return net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))), nil
+}
+
+// socksReply answers a SOCKS5 request, code 0 meaning success
+func socksReply(c net.Conn, code byte) error {
+	_, err := c.Write([]byte{5, code, 0, 1, 0, 0, 0, 0, 0, 0})
+	return err
+}
+
+// formatBytes formats a byte count for display
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	value, suffix := float64(n)/unit, "KiB"
+	for _, next := range []string{"MiB", "GiB", "TiB"} {
+		if value < unit {
+			break
+		}
+		value, suffix = value/unit, next
+	}
+	return fmt.Sprintf("%.1f %s", value, suffix)
+}
+
+// forwardsFromFlags collects the forwards of the tunnel command: named
+// presets and ssh-style -L, -R and -D specs
+func forwardsFromFlags(ctx *cli.Context) ([]config.Forward, error) {
+	var forwards []config.Forward
+	for _, name := range ctx.StringSlice("forward") {
+		forward, ok := appConfig.Forward(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown forward %q (add it to forwards in ~/.relocate/config.json)", name)
+		}
+		forwards = append(forwards, forward)
+	}
+	for _, flag := range []struct{ name, kind string }{
+		{"local", config.ForwardLocal},
+		{"remote", config.ForwardRemote},
+		{"dynamic", config.ForwardDynamic},
+	} {
+		for _, spec := range ctx.StringSlice(flag.name) {
+			forward, err := config.ParseForward(flag.kind, spec)
+			if err != nil {
+				return nil, err
+			}
+			forwards = append(forwards, forward)
+		}
+	}
+	if len(forwards) == 0 {
+		return nil, errors.New("name a forward with --forward, or give -L, -R or -D")
+	}
+	return forwards, nil
+}
+
+// runTunnels opens forwards through the instance named by query and keeps
+// them open until interrupted or the connection drops
+func runTunnels(overrides config.Overrides, filterTag string, offline bool, query string, forwards []config.Forward) error {
+	instances, warnings, err := fetchInventory(overrides, filterTag, offline)
+	if err != nil {
+		return err
+	}
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s\n", warning)
+	}
+	inst, err := findInstance(instances, query)
+	if err != nil {
+		return err
+	}
+
+	target := model{overrides: overrides, instances: instances}.resolveTarget(inst)
+	tunnels, err := openTunnels(target, forwards, true)
+	if err != nil {
+		return err
+	}
+
+	via := target.transport
+	if target.conn.ProxyJump != "" {
+		via += " through " + target.conn.ProxyJump
+	}
+	fmt.Printf("Forwarding through %s (%s) via %s:\n", instanceLabel(inst), inst.ID, via)
+	for _, t := range tunnels {
+		fmt.Printf("  %-20s %s\n", t.forward.Name, t.forward)
+	}
+	fmt.Println("Press Ctrl+C to close the tunnels.")
+
+	interrupted := make(chan os.Signal, 1)
+	signal.Notify(interrupted, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(interrupted)
+	// A tunnel closing by itself means its listener failed or the
+	// connection they share dropped
+	failed := make(chan struct{}, len(tunnels))
+	for _, t := range tunnels {
+		go func() {
+			<-t.done
+			failed <- struct{}{}
+		}()
+	}
+	select {
+	case <-interrupted:
+	case <-failed:
+	}
+
+	var errs []error
+	for _, t := range tunnels {
+		if _, err := t.status(); err != nil {
+			errs = append(errs, err)
+		}
+		t.Close()
+		fmt.Printf("%s: %d connections, %s sent, %s received\n", t.forward, t.total.Load(), formatBytes(t.sent.Load()), formatBytes(t.received.Load()))
+	}
+	return errors.Join(errs...)
+}
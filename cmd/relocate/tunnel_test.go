@@ -0,0 +1,95 @@
+package main
+
+import (
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestSOCKSHandshake(t *testing.T) {
+	tests := []struct {
+		name     string
+		greeting []byte
+		request  []byte
+		want     string
+		wantErr  bool
+	}{
+		{
+			name:     "IPv4",
+			greeting: []byte{5, 1, 0},
+			request:  []byte{5, 1, 0, 1, 10, 0, 0, 1, 0x1f, 0x90},
+			want:     "10.0.0.1:8080",
+		},
+		{
+			name:     "IPv6",
+			greeting: []byte{5, 1, 0},
+			request:  []byte{5, 1, 0, 4, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 1, 0, 22},
+			want:     "[::1]:22",
+		},
+		{
+			name:     "domain name",
+			greeting: []byte{5, 2, 2, 0},
+			request:  append(append([]byte{5, 1, 0, 3, 11}, "db.internal"...), 0x15, 0x38),
+			want:     "db.internal:5432",
+		},
+		{
+			name:     "SOCKS4",
+			greeting: []byte{4, 1},
+			wantErr:  true,
+		},
+		{
+			name:     "authentication required",
+			greeting: []byte{5, 1, 2},
+			wantErr:  true,
+		},
+		{
+			// Requests the handshake rejects are cut short where it stops reading
+			name:     "BIND",
+			greeting: []byte{5, 1, 0},
+			request:  []byte{5, 2, 0, 1},
+			wantErr:  true,
+		},
+		{
+			name:     "unknown address type",
+			greeting: []byte{5, 1, 0},
+			request:  []byte{5, 1, 0, 9},
+			wantErr:  true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, client := net.Pipe()
+			server.SetDeadline(time.Now().Add(5 * time.Second))
+
+			// The client sends its request once the method is chosen, and
+			// reads whatever else the server answers
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				defer client.Close()
+				if _, err := client.Write(tt.greeting); err != nil {
+					return
+				}
+				if _, err := io.ReadFull(client, make([]byte, 2)); err != nil {
+					return
+				}
+				if _, err := client.Write(tt.request); err != nil {
+					return
+				}
+				io.Copy(io.Discard, client)
+			}()
+
+			got, err := socksHandshake(server)
+			server.Close()
+			<-done
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("socksHandshake() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("socksHandshake() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
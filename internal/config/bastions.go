@@ -0,0 +1,54 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Bastion is a jump host used to reach instances that have no public
+// address. It is either a static host or the instance carrying the given
+// tags in the target's VPC.
+type Bastion struct {
+	Name   string            `json:"name"`
+	Host   string            `json:"host"`    // static host name or address
+	Tags   map[string]string `json:"tags"`    // tag key -> value of a bastion instance, "*" matches any value
+	User   string            `json:"user"`    // default: the target's ssh user
+	Port   int               `json:"port"`    // default: 22
+	VpcIDs []string          `json:"vpc_ids"` // VPCs always reached through this bastion; empty serves every VPC
+}
+
+// Serves reports whether the bastion can reach instances in the given VPC
+func (b Bastion) Serves(vpcID string) bool {
+	return len(b.VpcIDs) == 0 || slices.Contains(b.VpcIDs, vpcID)
+}
+
+// Required reports whether instances in the given VPC must go through the
+// bastion even when they have a public address
+func (b Bastion) Required(vpcID string) bool {
+	return slices.Contains(b.VpcIDs, vpcID)
+}
+
+// MatchesInstance reports whether an instance with the given tags is a
+// bastion of this kind
+func (b Bastion) MatchesInstance(tags map[string]string) bool {
+	return len(b.Tags) > 0 && matchTags(b.Tags, tags)
+}
+
+// validateBastions checks that every bastion names exactly one way to find it
+func (c Config) validateBastions() error {
+	for i, b := range c.Bastions {
+		name := b.Name
+		if name == "" {
+			name = fmt.Sprintf("bastions[%d]", i)
+		}
+		switch {
+		case b.Host == "" && len(b.Tags) == 0:
+			return fmt.Errorf("%w: bastion %s: host or tags is required", ErrConfigInvalid, name)
+		case b.Host != "" && len(b.Tags) > 0:
+			return fmt.Errorf("%w: bastion %s: host and tags are mutually exclusive", ErrConfigInvalid, name)
+		case b.Port < 0 || b.Port > 65535:
+			return fmt.Errorf("%w: bastion %s: invalid port %d", ErrConfigInvalid, name, b.Port)
+		}
+	}
+	return nil
+}
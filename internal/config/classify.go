@@ -0,0 +1,173 @@
+package config
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+// ClassificationRule assigns an environment to instances that match all of
+// its conditions. Empty conditions are ignored, so a rule without conditions
+// matches every instance.
+type ClassificationRule struct {
+	Environment string            `json:"environment"`
+	Tags        map[string]string `json:"tags"`     // tag key -> value, "*" matches any value
+	VpcID       string            `json:"vpc_id"`   // exact VPC ID
+	Account     string            `json:"account"`  // account ID or alias
+	Name        string            `json:"name"`     // regular expression on the Name tag
+	KeyName     string            `json:"key_name"` // regular expression on the key pair name
+
+	name    *regexp.Regexp
+	keyName *regexp.Regexp
+}
+
+// InstanceAttributes are the instance properties classification rules match on
+type InstanceAttributes struct {
+	Name      string
+	KeyName   string
+	VpcID     string
+	AccountID string
+	Profile   string
+	Region    string
+	Tags      map[string]string
+}
+
+// Unclassified is the environment of instances that match no classification rule
+const Unclassified = "unclassified"
+
+// compileClassification compiles the regular expressions of the classification rules
+func (c *Config) compileClassification() error {
+	for i := range c.Classification {
+		rule := &c.Classification[i]
+		if rule.Environment == "" {
+			return fmt.Errorf("%w: classification[%d]: environment is required", ErrConfigInvalid, i)
+		}
+
+		var err error
+		if rule.Name != "" {
+			if rule.name, err = regexp.Compile(rule.Name); err != nil {
+				return fmt.Errorf("%w: classification[%d].name: %w", ErrConfigInvalid, i, err)
+			}
+		}
+		if rule.KeyName != "" {
+			if rule.keyName, err = regexp.Compile(rule.KeyName); err != nil {
+				return fmt.Errorf("%w: classification[%d].key_name: %w", ErrConfigInvalid, i, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Classify returns the environment of an instance:
+//  1. the first classification rule whose conditions all match
+//  2. otherwise the environment whose aws_profile (and aws_region, if set)
+//     the instance was discovered with, matching lists and globs as
+//     discovery does
+//  3. without configured rules, the first environment whose name is one or
+//     more whole "-" or "_" separated words of the key pair name
+//
+// Returns Unclassified if none of these apply.
+func (c Config) Classify(inst InstanceAttributes) string {
+	for _, rule := range c.Classification {
+		if c.matches(rule, inst) {
+			return rule.Environment
+		}
+	}
+
+	for _, env := range c.EnvironmentList() {
+		if env.AWSProfile != "" && matchesProfile(env.AWSProfile, inst.Profile) &&
+			(env.AWSRegion == "" || matchesRegion(env.AWSRegion, inst.Region)) {
+			return env.Name
+		}
+	}
+
+	if len(c.Classification) == 0 {
+		for _, env := range c.EnvironmentList() {
+			if containsWords(inst.KeyName, env.Name) {
+				return env.Name
+			}
+		}
+	}
+
+	return Unclassified
+}
+
+func (c Config) matches(rule ClassificationRule, inst InstanceAttributes) bool {
+	if !matchTags(rule.Tags, inst.Tags) {
+		return false
+	}
+	if rule.VpcID != "" && rule.VpcID != inst.VpcID {
+		return false
+	}
+	if rule.Account != "" && rule.Account != inst.AccountID && rule.Account != c.AccountAlias(inst.AccountID) {
+		return false
+	}
+	if rule.name != nil && !rule.name.MatchString(inst.Name) {
+		return false
+	}
+	if rule.keyName != nil && !rule.keyName.MatchString(inst.KeyName) {
+		return false
+	}
+	return true
+}
+
+// MatchProfile reports whether a profile name matches pattern, a profile
+// name or a glob such as "team-*"
+func MatchProfile(pattern, profile string) (bool, error) {
+	return path.Match(pattern, profile)
+}
+
+// matchesProfile reports whether profile is one of those an aws_profile
+// setting names: a comma separated list of profile names and globs
+func matchesProfile(setting, profile string) bool {
+	for _, pattern := range strings.Split(setting, ",") {
+		if ok, _ := MatchProfile(strings.TrimSpace(pattern), profile); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesRegion reports whether region is one of those an aws_region
+// setting names: a comma separated list of regions, or "all"
+func matchesRegion(setting, region string) bool {
+	for _, r := range strings.Split(setting, ",") {
+		if r = strings.TrimSpace(r); r == "all" || r == region {
+			return true
+		}
+	}
+	return false
+}
+
+// containsWords reports whether the "-" or "_" separated words of name
+// appear, in order and next to each other, among the words of s. "prod"
+// is in "prod-web" and "team_prod" but not in "preprod-web".
+func containsWords(s, name string) bool {
+	split := func(s string) []string {
+		return strings.FieldsFunc(s, func(r rune) bool { return r == '-' || r == '_' })
+	}
+	words, want := split(s), split(name)
+	if len(want) == 0 {
+		return false
+	}
+	for i := 0; i+len(want) <= len(words); i++ {
+		if slices.Equal(words[i:i+len(want)], want) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchTags reports whether tags has every key in want with the wanted
+// value, where "*" matches any value
+func matchTags(want, tags map[string]string) bool {
+	for key, value := range want {
+		got, ok := tags[key]
+		if !ok || (value != "*" && got != value) {
+			return false
+		}
+	}
+	return true
+}
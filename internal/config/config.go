@@ -6,33 +6,92 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"time"
 )
 
 // Config holds the application configuration
 type Config struct {
-	SSHKeys  map[string]string `json:"ssh_keys"`
-	Defaults struct {
-		AWSProfile string `json:"aws_profile"`
-		AWSRegion  string `json:"aws_region"`
-		SSHUser    string `json:"ssh_user"`
+	Environments   []Environment     `json:"environments"`
+	SSHKeys        map[string]string `json:"ssh_keys"` // legacy: environment name -> key name
+	AccountAliases map[string]string `json:"account_aliases"`
+	Defaults       struct {
+		AWSProfile string   `json:"aws_profile"`
+		AWSRegion  string   `json:"aws_region"`
+		SSHUser    string   `json:"ssh_user"`
+		SSHPort    int      `json:"ssh_port"`
+		SSHKeyPath string   `json:"ssh_key_path"`
+		SSHOptions []string `json:"ssh_options"`
+		Transport  string   `json:"transport"`
+		Auth       string   `json:"auth"`
+
+		AddressPreference []string `json:"address_preference"`
 	} `json:"defaults"`
+	TransportTag string `json:"transport_tag"` // instance tag selecting a transport, default "relocate:transport"
+	SessionLoop  bool   `json:"session_loop"`  // return to the browser when a session ends
+	Discovery    struct {
+		MaxInstances int    `json:"max_instances"`
+		Timeout      string `json:"timeout"`
+		Parallelism  int    `json:"parallelism"`
+	} `json:"discovery"`
+	Classification []ClassificationRule `json:"classification"`
+	Bastions       []Bastion            `json:"bastions"`
+	SavedSearches  []SavedSearch        `json:"saved_searches"`
+	Forwards       []Forward            `json:"forwards"`
+	Cache          struct {
+		TTL string `json:"ttl"`
+		Dir string `json:"dir"`
+	} `json:"cache"`
+	Broadcast struct {
+		Parallelism int    `json:"parallelism"`
+		Timeout     string `json:"timeout"`
+		Engine      string `json:"engine"` // "native" (default) or "ssh"
+	} `json:"broadcast"`
+	Tmux struct {
+		Layout      string `json:"layout"`      // "tiled" or "windows"
+		Synchronize bool   `json:"synchronize"` // type into every pane of a tiled window at once
+	} `json:"tmux"`
 }
 
+// DefaultDiscoveryTimeout bounds instance discovery when no timeout is configured
+const DefaultDiscoveryTimeout = 2 * time.Minute
+
+// DefaultCacheTTL is how long a cached inventory is shown at startup when
+// no cache.ttl is configured
+const DefaultCacheTTL = time.Hour
+
+// DefaultBroadcastParallelism is the number of instances a broadcast command
+// runs on at once when broadcast.parallelism is not set
+const DefaultBroadcastParallelism = 8
+
+// DefaultBroadcastTimeout bounds a broadcast command on one instance when no
+// timeout is configured
+const DefaultBroadcastTimeout = 5 * time.Minute
+
 var (
 	ErrConfigNotFound      = errors.New("config file not found")
 	ErrConfigInvalid       = errors.New("config file is invalid")
 	ErrSSHKeyNotConfigured = errors.New("SSH key not configured")
 )
 
+// Dir returns the directory relocate keeps its files in, ~/.relocate
+func Dir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".relocate"), nil
+}
+
 // Load reads the configuration from ~/.relocate/config.json
 // Returns an error if the file doesn't exist or is invalid
 func Load() (Config, error) {
-	homeDir, err := os.UserHomeDir()
+	dir, err := Dir()
 	if err != nil {
-		return Config{}, fmt.Errorf("%w: failed to get home directory: %w", ErrConfigNotFound, err)
+		return Config{}, fmt.Errorf("%w: %w", ErrConfigNotFound, err)
 	}
 
-	configPath := filepath.Join(homeDir, ".relocate", "config.json")
+	configPath := filepath.Join(dir, "config.json")
 	data, err := os.ReadFile(configPath)
 	if err != nil {
 		return Config{}, fmt.Errorf("%w: %s (run: mkdir -p ~/.relocate && cp config.example.json ~/.relocate/config.json)", ErrConfigNotFound, configPath)
@@ -43,31 +102,156 @@ func Load() (Config, error) {
 		return Config{}, fmt.Errorf("%w: %w", ErrConfigInvalid, err)
 	}
 
-	// Validate ssh_keys exists and is not empty
-	if cfg.SSHKeys == nil || len(cfg.SSHKeys) == 0 {
-		return Config{}, fmt.Errorf("%w: ssh_keys section is empty", ErrConfigInvalid)
+	// Validate that at least one environment is declared
+	if len(cfg.Environments) == 0 && len(cfg.SSHKeys) == 0 {
+		return Config{}, fmt.Errorf("%w: environments section is empty", ErrConfigInvalid)
+	}
+
+	if err := cfg.compileClassification(); err != nil {
+		return Config{}, err
 	}
 
 	return cfg, nil
 }
 
-// GetSSHKey returns the SSH key name for the given environment
-// Returns an error if the environment is not configured
-func (c Config) GetSSHKey(env string) (string, error) {
-	if key, ok := c.SSHKeys[env]; ok && key != "" {
-		return key, nil
+// AccountAlias returns the configured alias for an AWS account ID
+// Falls back to the account ID itself when no alias is configured
+func (c Config) AccountAlias(accountID string) string {
+	if alias, ok := c.AccountAliases[accountID]; ok && alias != "" {
+		return alias
+	}
+	return accountID
+}
+
+// DiscoveryTimeout returns how long instance discovery may run
+// Returns DefaultDiscoveryTimeout if discovery.timeout is not set, and 0 if it is "0"
+func (c Config) DiscoveryTimeout() (time.Duration, error) {
+	if c.Discovery.Timeout == "" {
+		return DefaultDiscoveryTimeout, nil
+	}
+	d, err := time.ParseDuration(c.Discovery.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("%w: discovery.timeout: %w", ErrConfigInvalid, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%w: discovery.timeout must not be negative", ErrConfigInvalid)
+	}
+	return d, nil
+}
+
+// CacheTTL returns how old a cached inventory may be to be shown at startup
+// Returns DefaultCacheTTL if cache.ttl is not set, and 0 (cache disabled) if it is "0"
+func (c Config) CacheTTL() (time.Duration, error) {
+	if c.Cache.TTL == "" {
+		return DefaultCacheTTL, nil
+	}
+	d, err := time.ParseDuration(c.Cache.TTL)
+	if err != nil {
+		return 0, fmt.Errorf("%w: cache.ttl: %w", ErrConfigInvalid, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%w: cache.ttl must not be negative", ErrConfigInvalid)
+	}
+	return d, nil
+}
+
+// CacheDir returns the directory inventories are cached in: cache.dir, or
+// relocate in the user cache directory (e.g. $XDG_CACHE_HOME), or
+// ~/.relocate/cache
+func (c Config) CacheDir() (string, error) {
+	if c.Cache.Dir != "" {
+		return expandHome(c.Cache.Dir), nil
 	}
-	return "", fmt.Errorf("%w: %s (add it to ~/.relocate/config.json)", ErrSSHKeyNotConfigured, env)
+	if dir, err := os.UserCacheDir(); err == nil {
+		return filepath.Join(dir, "relocate"), nil
+	}
+	dir, err := Dir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, "cache"), nil
+}
+
+// BroadcastParallelism returns the number of instances a broadcast command
+// runs on at once
+func (c Config) BroadcastParallelism() int {
+	if c.Broadcast.Parallelism > 0 {
+		return c.Broadcast.Parallelism
+	}
+	return DefaultBroadcastParallelism
+}
+
+// BroadcastTimeout returns how long a broadcast command may run on one instance
+// Returns DefaultBroadcastTimeout if broadcast.timeout is not set, and 0 if it is "0"
+func (c Config) BroadcastTimeout() (time.Duration, error) {
+	if c.Broadcast.Timeout == "" {
+		return DefaultBroadcastTimeout, nil
+	}
+	d, err := time.ParseDuration(c.Broadcast.Timeout)
+	if err != nil {
+		return 0, fmt.Errorf("%w: broadcast.timeout: %w", ErrConfigInvalid, err)
+	}
+	if d < 0 {
+		return 0, fmt.Errorf("%w: broadcast.timeout must not be negative", ErrConfigInvalid)
+	}
+	return d, nil
+}
+
+// BroadcastEngine returns the engine that runs broadcast commands,
+// EngineNative by default
+func (c Config) BroadcastEngine() string {
+	if c.Broadcast.Engine == "" {
+		return EngineNative
+	}
+	return c.Broadcast.Engine
 }
 
 // Validate checks if the config is properly set up
 func (c Config) Validate() error {
-	// Check that at least staging and prod keys are configured
-	if _, ok := c.SSHKeys["staging"]; !ok {
-		return fmt.Errorf("%w: staging SSH key not configured", ErrConfigInvalid)
+	if err := c.validateEnvironments(); err != nil {
+		return err
+	}
+	if !validTransport(c.Defaults.Transport) {
+		return fmt.Errorf("%w: defaults.transport: unknown transport %q", ErrConfigInvalid, c.Defaults.Transport)
+	}
+	if !validAuth(c.Defaults.Auth) {
+		return fmt.Errorf("%w: defaults.auth: unknown auth %q", ErrConfigInvalid, c.Defaults.Auth)
+	}
+	if kind, ok := validAddresses(c.Defaults.AddressPreference); !ok {
+		return fmt.Errorf("%w: defaults.address_preference: unknown address kind %q", ErrConfigInvalid, kind)
+	}
+	if err := c.validateBastions(); err != nil {
+		return err
+	}
+	if err := c.validateSavedSearches(); err != nil {
+		return err
+	}
+	if err := c.validateForwards(); err != nil {
+		return err
+	}
+	if c.Discovery.MaxInstances < 0 {
+		return fmt.Errorf("%w: discovery.max_instances must not be negative", ErrConfigInvalid)
+	}
+	if c.Discovery.Parallelism < 0 {
+		return fmt.Errorf("%w: discovery.parallelism must not be negative", ErrConfigInvalid)
+	}
+	if _, err := c.DiscoveryTimeout(); err != nil {
+		return err
+	}
+	if _, err := c.CacheTTL(); err != nil {
+		return err
+	}
+	if c.Broadcast.Parallelism < 0 {
+		return fmt.Errorf("%w: broadcast.parallelism must not be negative", ErrConfigInvalid)
+	}
+	if _, err := c.BroadcastTimeout(); err != nil {
+		return err
+	}
+	if c.Broadcast.Engine != "" && !slices.Contains(Engines, c.Broadcast.Engine) {
+		return fmt.Errorf("%w: broadcast.engine: unknown engine %q", ErrConfigInvalid, c.Broadcast.Engine)
 	}
-	if _, ok := c.SSHKeys["prod"]; !ok {
-		return fmt.Errorf("%w: prod SSH key not configured", ErrConfigInvalid)
+	if err := c.validateTmux(); err != nil {
+		return err
 	}
 	return nil
 }
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"cmp"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Built-in connection settings used when neither flags, the environment nor
+// the defaults block set them
+const (
+	DefaultSSHUser = "ubuntu"
+	DefaultSSHPort = 22
+)
+
+// Transports relocate can connect with
+const (
+	TransportSSH    = "ssh"     // ssh to the instance address
+	TransportSSHSSM = "ssh-ssm" // ssh tunnelled through SSM Session Manager (AWS-StartSSHSession)
+	TransportSSM    = "ssm"     // SSM Session Manager shell, no ssh involved
+	TransportNative = "native"  // built-in SSH client to the instance address, no ssh binary needed
+)
+
+// Transports lists the supported transports in toggle order
+var Transports = []string{TransportSSH, TransportSSHSSM, TransportSSM, TransportNative}
+
+// DirectTransport reports whether a transport connects to one of the
+// instance's addresses, possibly through a bastion, rather than through SSM
+func DirectTransport(t string) bool {
+	return t == TransportSSH || t == TransportNative
+}
+
+// Engines that run broadcast commands
+const (
+	EngineNative = "native" // the built-in SSH client, reusing bastion connections
+	EngineSSH    = "ssh"    // the ssh binary, one process per instance
+)
+
+// Engines lists the supported broadcast engines
+var Engines = []string{EngineNative, EngineSSH}
+
+// DefaultTransportTag is the instance tag that selects a transport per instance
+const DefaultTransportTag = "relocate:transport"
+
+// Authentication methods for ssh transports
+const (
+	AuthKey             = "key"              // the configured private key
+	AuthInstanceConnect = "instance-connect" // an ephemeral key pushed with EC2 Instance Connect
+)
+
+// AuthMethods lists the supported authentication methods
+var AuthMethods = []string{AuthKey, AuthInstanceConnect}
+
+// Address kinds an instance can be reached at
+const (
+	AddressPublic     = "public"      // public IPv4 address
+	AddressPrivate    = "private"     // private IPv4 address
+	AddressIPv6       = "ipv6"        // IPv6 address
+	AddressPublicDNS  = "public-dns"  // public DNS name
+	AddressPrivateDNS = "private-dns" // private DNS name
+)
+
+// AddressKinds lists the address kinds in toggle order
+var AddressKinds = []string{AddressPublic, AddressPrivate, AddressIPv6, AddressPublicDNS, AddressPrivateDNS}
+
+// DefaultAddressPreference is used when no address_preference is configured:
+// the public IP, then the private IP, then IPv6
+var DefaultAddressPreference = []string{AddressPublic, AddressPrivate, AddressIPv6}
+
+// Overrides are connection settings given on the command line
+// Empty fields are not set
+type Overrides struct {
+	SSHUser    string
+	SSHPort    int
+	SSHKeyPath string
+	SSHOptions []string
+	AWSProfile string
+	AWSRegion  string
+	Transport  string
+	Auth       string
+	Address    []string
+}
+
+// Connection holds the resolved settings used to reach an environment
+type Connection struct {
+	User        string
+	Port        int
+	KeyPath     string // empty if no key is configured
+	JumpKeyPath string // key for the bastion, the configured one even when KeyPath is ephemeral
+	Options     []string
+	AWSProfile  string
+	AWSRegion   string
+	Transport   string
+	Auth        string
+	ProxyJump   string   // [user@]host[:port] to jump through, empty to connect directly
+	Addresses   []string // address kinds in order of preference
+	Address     string   // address kind chosen for this connection, empty to use Addresses
+}
+
+// ResolveConnection resolves the connection settings for an environment.
+// Every setting is taken from the first place that sets it:
+//
+//  1. the command line flags (overrides)
+//  2. the environment's block in config.json
+//  3. the defaults block in config.json
+//  4. built-in defaults (user "ubuntu", port 22, transport "ssh", auth "key",
+//     addresses public, private, ipv6)
+//
+// The key path comes from --identity, the environment's ssh_key_path, its
+// ssh_key (a filename in ~/.ssh, or its entry in the legacy ssh_keys map),
+// and finally defaults.ssh_key_path. SSH options from all levels are
+// combined, flags first, because ssh uses the first value given for an
+// option.
+func (c Config) ResolveConnection(envName string, overrides Overrides) Connection {
+	env, _ := c.Environment(envName)
+
+	conn := Connection{
+		User:       cmp.Or(overrides.SSHUser, env.SSHUser, c.Defaults.SSHUser, DefaultSSHUser),
+		Port:       cmp.Or(overrides.SSHPort, env.SSHPort, c.Defaults.SSHPort, DefaultSSHPort),
+		AWSProfile: cmp.Or(overrides.AWSProfile, env.AWSProfile, c.Defaults.AWSProfile),
+		AWSRegion:  cmp.Or(overrides.AWSRegion, env.AWSRegion, c.Defaults.AWSRegion),
+		Transport:  cmp.Or(overrides.Transport, env.Transport, c.Defaults.Transport, TransportSSH),
+		Auth:       cmp.Or(overrides.Auth, env.Auth, c.Defaults.Auth, AuthKey),
+	}
+
+	for _, addresses := range [][]string{overrides.Address, env.AddressPreference, c.Defaults.AddressPreference, DefaultAddressPreference} {
+		if len(addresses) > 0 {
+			conn.Addresses = addresses
+			break
+		}
+	}
+
+	conn.Options = append(conn.Options, overrides.SSHOptions...)
+	conn.Options = append(conn.Options, env.SSHOptions...)
+	conn.Options = append(conn.Options, c.Defaults.SSHOptions...)
+
+	switch {
+	case overrides.SSHKeyPath != "":
+		conn.KeyPath = expandHome(overrides.SSHKeyPath)
+	case env.SSHKeyPath != "":
+		conn.KeyPath = expandHome(env.SSHKeyPath)
+	case env.SSHKey != "":
+		conn.KeyPath = expandHome(filepath.Join("~", ".ssh", env.SSHKey))
+	case c.Defaults.SSHKeyPath != "":
+		conn.KeyPath = expandHome(c.Defaults.SSHKeyPath)
+	}
+	conn.JumpKeyPath = conn.KeyPath
+
+	return conn
+}
+
+// TransportFor returns the transport for an instance with the given tags.
+// A transport given on the command line wins, then the instance's transport
+// tag, then the transport resolved for its environment.
+func (c Config) TransportFor(conn Connection, overrides Overrides, tags map[string]string) string {
+	if overrides.Transport != "" {
+		return overrides.Transport
+	}
+	if t := tags[c.TransportTagKey()]; slices.Contains(Transports, t) {
+		return t
+	}
+	return conn.Transport
+}
+
+// TransportTagKey returns the tag key that selects a transport per instance
+func (c Config) TransportTagKey() string {
+	return cmp.Or(c.TransportTag, DefaultTransportTag)
+}
+
+// validTransport reports whether t is empty or a supported transport
+func validTransport(t string) bool {
+	return t == "" || slices.Contains(Transports, t)
+}
+
+// validAuth reports whether a is empty or a supported authentication method
+func validAuth(a string) bool {
+	return a == "" || slices.Contains(AuthMethods, a)
+}
+
+// validAddresses returns the first entry of kinds that is not a known address kind
+func validAddresses(kinds []string) (string, bool) {
+	for _, kind := range kinds {
+		if !slices.Contains(AddressKinds, kind) {
+			return kind, false
+		}
+	}
+	return "", true
+}
+
+// expandHome replaces a leading ~ with the user's home directory
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(homeDir, path[1:])
+}
@@ -0,0 +1,184 @@
+package config
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestResolveConnection(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+
+	// builtin is the connection resolved when nothing is configured, changed by set
+	builtin := func(set func(c *Connection)) Connection {
+		conn := Connection{
+			User:      DefaultSSHUser,
+			Port:      DefaultSSHPort,
+			Transport: TransportSSH,
+			Auth:      AuthKey,
+			Addresses: DefaultAddressPreference,
+		}
+		if set != nil {
+			set(&conn)
+		}
+		return conn
+	}
+
+	// every sets each level: flags, the staging environment and defaults
+	every := func(c *Config) {
+		c.Environments = []Environment{{
+			Name:       "staging",
+			SSHUser:    "env-user",
+			SSHPort:    2200,
+			SSHKeyPath: "~/keys/env.pem",
+			SSHKey:     "env-key.pem",
+			SSHOptions: []string{"ServerAliveInterval=30", "ConnectTimeout=10"},
+			AWSProfile: "env-profile",
+			AWSRegion:  "eu-west-1",
+		}}
+		c.Defaults.SSHUser = "default-user"
+		c.Defaults.SSHPort = 2222
+		c.Defaults.SSHKeyPath = "/etc/relocate/default.pem"
+		c.Defaults.SSHOptions = []string{"ConnectTimeout=5", "StrictHostKeyChecking=accept-new"}
+		c.Defaults.AWSProfile = "default-profile"
+		c.Defaults.AWSRegion = "us-east-1"
+	}
+	flags := Overrides{
+		SSHUser:    "flag-user",
+		SSHPort:    2022,
+		SSHKeyPath: "~/flag.pem",
+		SSHOptions: []string{"ConnectTimeout=1"},
+		AWSProfile: "flag-profile",
+		AWSRegion:  "ap-southeast-1",
+	}
+
+	tests := []struct {
+		name      string
+		setup     func(c *Config)
+		overrides Overrides
+		want      Connection
+	}{
+		{
+			name: "built-in",
+			want: builtin(nil),
+		},
+		{
+			name: "defaults",
+			setup: func(c *Config) {
+				every(c)
+				c.Environments = []Environment{{Name: "staging"}}
+			},
+			want: builtin(func(c *Connection) {
+				c.User = "default-user"
+				c.Port = 2222
+				c.KeyPath = "/etc/relocate/default.pem"
+				c.Options = []string{"ConnectTimeout=5", "StrictHostKeyChecking=accept-new"}
+				c.AWSProfile = "default-profile"
+				c.AWSRegion = "us-east-1"
+			}),
+		},
+		{
+			name:  "environment over defaults",
+			setup: every,
+			want: builtin(func(c *Connection) {
+				c.User = "env-user"
+				c.Port = 2200
+				c.KeyPath = filepath.Join(home, "keys", "env.pem")
+				c.Options = []string{"ServerAliveInterval=30", "ConnectTimeout=10", "ConnectTimeout=5", "StrictHostKeyChecking=accept-new"}
+				c.AWSProfile = "env-profile"
+				c.AWSRegion = "eu-west-1"
+			}),
+		},
+		{
+			name:      "flags over environment",
+			setup:     every,
+			overrides: flags,
+			want: builtin(func(c *Connection) {
+				c.User = "flag-user"
+				c.Port = 2022
+				c.KeyPath = filepath.Join(home, "flag.pem")
+				c.Options = []string{"ConnectTimeout=1", "ServerAliveInterval=30", "ConnectTimeout=10", "ConnectTimeout=5", "StrictHostKeyChecking=accept-new"}
+				c.AWSProfile = "flag-profile"
+				c.AWSRegion = "ap-southeast-1"
+			}),
+		},
+		{
+			name:      "flags over built-in",
+			overrides: flags,
+			want: builtin(func(c *Connection) {
+				c.User = "flag-user"
+				c.Port = 2022
+				c.KeyPath = filepath.Join(home, "flag.pem")
+				c.Options = []string{"ConnectTimeout=1"}
+				c.AWSProfile = "flag-profile"
+				c.AWSRegion = "ap-southeast-1"
+			}),
+		},
+		{
+			name: "ssh_key over defaults key path",
+			setup: func(c *Config) {
+				every(c)
+				c.Environments[0].SSHKeyPath = ""
+			},
+			want: builtin(func(c *Connection) {
+				c.User = "env-user"
+				c.Port = 2200
+				c.KeyPath = filepath.Join(home, ".ssh", "env-key.pem")
+				c.Options = []string{"ServerAliveInterval=30", "ConnectTimeout=10", "ConnectTimeout=5", "StrictHostKeyChecking=accept-new"}
+				c.AWSProfile = "env-profile"
+				c.AWSRegion = "eu-west-1"
+			}),
+		},
+		{
+			name: "legacy ssh_keys",
+			setup: func(c *Config) {
+				c.SSHKeys = map[string]string{"staging": "legacy.pem", "prod": "prod.pem"}
+				c.Defaults.SSHKeyPath = "/etc/relocate/default.pem"
+			},
+			want: builtin(func(c *Connection) {
+				c.KeyPath = filepath.Join(home, ".ssh", "legacy.pem")
+			}),
+		},
+		{
+			name: "legacy ssh_keys under flag key path",
+			setup: func(c *Config) {
+				c.SSHKeys = map[string]string{"staging": "legacy.pem"}
+			},
+			overrides: Overrides{SSHKeyPath: "/tmp/flag.pem"},
+			want: builtin(func(c *Connection) {
+				c.KeyPath = "/tmp/flag.pem"
+			}),
+		},
+		{
+			name: "unknown environment uses defaults",
+			setup: func(c *Config) {
+				every(c)
+				c.Environments[0].Name = "prod"
+			},
+			want: builtin(func(c *Connection) {
+				c.User = "default-user"
+				c.Port = 2222
+				c.KeyPath = "/etc/relocate/default.pem"
+				c.Options = []string{"ConnectTimeout=5", "StrictHostKeyChecking=accept-new"}
+				c.AWSProfile = "default-profile"
+				c.AWSRegion = "us-east-1"
+			}),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var c Config
+			if tt.setup != nil {
+				tt.setup(&c)
+			}
+			want := tt.want
+			want.JumpKeyPath = want.KeyPath
+			got := c.ResolveConnection("staging", tt.overrides)
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("ResolveConnection() =\n  %+v\nwant\n  %+v", got, want)
+			}
+		})
+	}
+}
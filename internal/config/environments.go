@@ -0,0 +1,116 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+)
+
+// Environment is a named group of instances with its own connection settings
+type Environment struct {
+	Name       string   `json:"name"`
+	SSHKey     string   `json:"ssh_key"`      // key filename in ~/.ssh
+	SSHKeyPath string   `json:"ssh_key_path"` // key path, takes precedence over ssh_key
+	SSHUser    string   `json:"ssh_user"`
+	SSHPort    int      `json:"ssh_port"`
+	SSHOptions []string `json:"ssh_options"` // extra "-o" options, e.g. "StrictHostKeyChecking=accept-new"
+	AWSProfile string   `json:"aws_profile"`
+	AWSRegion  string   `json:"aws_region"`
+	Transport  string   `json:"transport"` // "ssh", "ssh-ssm" or "ssm"
+	Auth       string   `json:"auth"`      // "key" or "instance-connect"
+	Color      string   `json:"color"`     // hex colour of the environment tab, e.g. "#F59E0B"
+	Protected  bool     `json:"protected"` // instance actions need the instance name typed to confirm
+
+	AddressPreference []string `json:"address_preference"` // address kinds to try in order, e.g. ["private", "public"]
+}
+
+// EnvironmentList returns the environments in the order they are declared
+// Configs without an environments section use the legacy ssh_keys map,
+// ordered staging, prod, then the remaining names alphabetically
+func (c Config) EnvironmentList() []Environment {
+	if len(c.Environments) > 0 {
+		return c.Environments
+	}
+
+	var names []string
+	for name := range c.SSHKeys {
+		names = append(names, name)
+	}
+	slices.SortFunc(names, func(a, b string) int {
+		if r := legacyRank(a) - legacyRank(b); r != 0 {
+			return r
+		}
+		return strings.Compare(a, b)
+	})
+
+	var envs []Environment
+	for _, name := range names {
+		envs = append(envs, Environment{Name: name, SSHKey: c.SSHKeys[name], Protected: name == "prod"})
+	}
+	return envs
+}
+
+// legacyRank orders legacy ssh_keys environments: staging, prod, then alphabetical
+func legacyRank(name string) int {
+	switch name {
+	case "staging":
+		return -2
+	case "prod":
+		return -1
+	}
+	return 0
+}
+
+// Environment returns the environment with the given name
+func (c Config) Environment(name string) (Environment, bool) {
+	for _, env := range c.EnvironmentList() {
+		if env.Name == name {
+			return env, true
+		}
+	}
+	return Environment{}, false
+}
+
+// Protected reports whether instance actions in an environment need a typed
+// confirmation
+func (c Config) Protected(envName string) bool {
+	env, _ := c.Environment(envName)
+	return env.Protected
+}
+
+// validateEnvironments checks environment names and classification targets
+func (c Config) validateEnvironments() error {
+	envs := c.EnvironmentList()
+	if len(envs) == 0 {
+		return fmt.Errorf("%w: no environments configured", ErrConfigInvalid)
+	}
+
+	seen := make(map[string]bool)
+	for i, env := range envs {
+		switch {
+		case env.Name == "":
+			return fmt.Errorf("%w: environments[%d]: name is required", ErrConfigInvalid, i)
+		case env.Name == Unclassified:
+			return fmt.Errorf("%w: environments[%d]: %q is reserved", ErrConfigInvalid, i, Unclassified)
+		case seen[env.Name]:
+			return fmt.Errorf("%w: environment %q is declared twice", ErrConfigInvalid, env.Name)
+		case env.SSHPort < 0 || env.SSHPort > 65535:
+			return fmt.Errorf("%w: environment %q: invalid ssh_port %d", ErrConfigInvalid, env.Name, env.SSHPort)
+		case !validTransport(env.Transport):
+			return fmt.Errorf("%w: environment %q: unknown transport %q", ErrConfigInvalid, env.Name, env.Transport)
+		case !validAuth(env.Auth):
+			return fmt.Errorf("%w: environment %q: unknown auth %q", ErrConfigInvalid, env.Name, env.Auth)
+		}
+		if kind, ok := validAddresses(env.AddressPreference); !ok {
+			return fmt.Errorf("%w: environment %q: unknown address kind %q", ErrConfigInvalid, env.Name, kind)
+		}
+		seen[env.Name] = true
+	}
+
+	for i, rule := range c.Classification {
+		if !seen[rule.Environment] {
+			return fmt.Errorf("%w: classification[%d]: unknown environment %q", ErrConfigInvalid, i, rule.Environment)
+		}
+	}
+	return nil
+}
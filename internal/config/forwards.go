@@ -0,0 +1,164 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Forward types, named after the ssh flag they correspond to
+const (
+	ForwardLocal   = "local"   // -L: a local port forwarded to a host reached from the instance
+	ForwardRemote  = "remote"  // -R: a port on the instance forwarded to a host reached from here
+	ForwardDynamic = "dynamic" // -D: a local SOCKS5 proxy through the instance
+)
+
+// ForwardTypes lists the supported forward types
+var ForwardTypes = []string{ForwardLocal, ForwardRemote, ForwardDynamic}
+
+// Forward is a port forward through an instance, a named preset in config
+// or one given on the command line
+type Forward struct {
+	Name   string `json:"name"`
+	Type   string `json:"type"`   // "local" (default), "remote" or "dynamic"
+	Listen string `json:"listen"` // [address:]port, here for local and dynamic, on the instance for remote
+	Target string `json:"target"` // host:port, reached from the instance for local, from here for remote
+}
+
+// Kind returns the forward type, ForwardLocal by default
+func (f Forward) Kind() string {
+	if f.Type == "" {
+		return ForwardLocal
+	}
+	return f.Type
+}
+
+// ListenAddress returns the host:port to listen on. Without an address the
+// forward listens on the loopback interface only, as ssh does.
+func (f Forward) ListenAddress() string {
+	if _, _, err := net.SplitHostPort(f.Listen); err == nil {
+		return f.Listen
+	}
+	return net.JoinHostPort("127.0.0.1", f.Listen)
+}
+
+// String returns the forward as the ssh flag that sets it up
+func (f Forward) String() string {
+	switch f.Kind() {
+	case ForwardRemote:
+		return "-R " + f.Listen + ":" + f.Target
+	case ForwardDynamic:
+		return "-D " + f.Listen
+	}
+	return "-L " + f.Listen + ":" + f.Target
+}
+
+// Validate checks the listen address and target of a forward
+func (f Forward) Validate() error {
+	if !slices.Contains(ForwardTypes, f.Kind()) {
+		return fmt.Errorf("unknown type %q (use %s)", f.Type, strings.Join(ForwardTypes, ", "))
+	}
+	if err := validPort(f.ListenAddress()); err != nil {
+		return fmt.Errorf("listen %q: %w", f.Listen, err)
+	}
+	if f.Kind() == ForwardDynamic {
+		if f.Target != "" {
+			return fmt.Errorf("a dynamic forward has no target")
+		}
+		return nil
+	}
+	if err := validPort(f.Target); err != nil {
+		return fmt.Errorf("target %q: %w", f.Target, err)
+	}
+	return nil
+}
+
+// validPort checks that address is host:port with a port in 1-65535
+func validPort(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("expected host:port")
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q", port)
+	}
+	return nil
+}
+
+// ParseForward parses the argument of ssh's -L, -R or -D flag, given the
+// forward type it sets up: [address:]port:host:hostport for local and
+// remote forwards, [address:]port for dynamic ones. IPv6 addresses are
+// written in brackets.
+func ParseForward(kind, spec string) (Forward, error) {
+	fields := splitForward(spec)
+	f := Forward{Name: spec, Type: kind}
+	switch {
+	case kind == ForwardDynamic && len(fields) == 1:
+		f.Listen = fields[0]
+	case kind == ForwardDynamic && len(fields) == 2:
+		f.Listen = net.JoinHostPort(fields[0], fields[1])
+	case kind != ForwardDynamic && len(fields) == 3:
+		f.Listen = fields[0]
+		f.Target = net.JoinHostPort(fields[1], fields[2])
+	case kind != ForwardDynamic && len(fields) == 4:
+		f.Listen = net.JoinHostPort(fields[0], fields[1])
+		f.Target = net.JoinHostPort(fields[2], fields[3])
+	default:
+		return f, fmt.Errorf("invalid %s forward %q", kind, spec)
+	}
+	if err := f.Validate(); err != nil {
+		return f, fmt.Errorf("invalid %s forward %q: %w", kind, spec, err)
+	}
+	return f, nil
+}
+
+// splitForward splits a forward spec on the colons outside brackets and
+// strips the brackets
+func splitForward(spec string) []string {
+	var fields []string
+	depth, start := 0, 0
+	for i, r := range spec {
+		switch r {
+		case '[':
+			depth++
+		case ']':
+			depth--
+		case ':':
+			if depth == 0 {
+				fields = append(fields, strings.Trim(spec[start:i], "[]"))
+				start = i + 1
+			}
+		}
+	}
+	return append(fields, strings.Trim(spec[start:], "[]"))
+}
+
+// Forward returns the forward preset with the given name
+func (c Config) Forward(name string) (Forward, bool) {
+	for _, f := range c.Forwards {
+		if f.Name == name {
+			return f, true
+		}
+	}
+	return Forward{}, false
+}
+
+// validateForwards checks forward presets
+func (c Config) validateForwards() error {
+	seen := make(map[string]bool)
+	for i, f := range c.Forwards {
+		switch {
+		case f.Name == "":
+			return fmt.Errorf("%w: forwards[%d]: name is required", ErrConfigInvalid, i)
+		case seen[f.Name]:
+			return fmt.Errorf("%w: forward %q is declared twice", ErrConfigInvalid, f.Name)
+		}
+		if err := f.Validate(); err != nil {
+			return fmt.Errorf("%w: forward %q: %w", ErrConfigInvalid, f.Name, err)
+		}
+		seen[f.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,117 @@
+package config
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestParseForward(t *testing.T) {
+	tests := []struct {
+		name    string
+		kind    string
+		spec    string
+		want    Forward
+		wantErr bool
+	}{
+		{
+			name: "local",
+			kind: ForwardLocal,
+			spec: "8080:localhost:80",
+			want: Forward{Name: "8080:localhost:80", Type: ForwardLocal, Listen: "8080", Target: "localhost:80"},
+		},
+		{
+			name: "local with address",
+			kind: ForwardLocal,
+			spec: "0.0.0.0:5432:db.internal:5432",
+			want: Forward{Name: "0.0.0.0:5432:db.internal:5432", Type: ForwardLocal, Listen: "0.0.0.0:5432", Target: "db.internal:5432"},
+		},
+		{
+			name: "local with bracketed IPv6",
+			kind: ForwardLocal,
+			spec: "[::1]:8080:[fd00::1]:80",
+			want: Forward{Name: "[::1]:8080:[fd00::1]:80", Type: ForwardLocal, Listen: "[::1]:8080", Target: "[fd00::1]:80"},
+		},
+		{
+			name: "remote",
+			kind: ForwardRemote,
+			spec: "9000:localhost:3000",
+			want: Forward{Name: "9000:localhost:3000", Type: ForwardRemote, Listen: "9000", Target: "localhost:3000"},
+		},
+		{
+			name: "dynamic",
+			kind: ForwardDynamic,
+			spec: "1080",
+			want: Forward{Name: "1080", Type: ForwardDynamic, Listen: "1080"},
+		},
+		{
+			name: "dynamic with bracketed IPv6",
+			kind: ForwardDynamic,
+			spec: "[::1]:1080",
+			want: Forward{Name: "[::1]:1080", Type: ForwardDynamic, Listen: "[::1]:1080"},
+		},
+		{
+			name:    "local without target",
+			kind:    ForwardLocal,
+			spec:    "8080",
+			wantErr: true,
+		},
+		{
+			name:    "local with unbracketed IPv6",
+			kind:    ForwardLocal,
+			spec:    "8080:fd00::1:80",
+			wantErr: true,
+		},
+		{
+			name:    "dynamic with target",
+			kind:    ForwardDynamic,
+			spec:    "1080:localhost:80",
+			wantErr: true,
+		},
+		{
+			name:    "listen port out of range",
+			kind:    ForwardLocal,
+			spec:    "0:localhost:80",
+			wantErr: true,
+		},
+		{
+			name:    "target port not a number",
+			kind:    ForwardRemote,
+			spec:    "9000:localhost:http",
+			wantErr: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseForward(tt.kind, tt.spec)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseForward() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err == nil && got != tt.want {
+				t.Errorf("ParseForward() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSplitForward(t *testing.T) {
+	tests := []struct {
+		spec string
+		want []string
+	}{
+		{spec: "", want: []string{""}},
+		{spec: "1080", want: []string{"1080"}},
+		{spec: "8080:localhost:80", want: []string{"8080", "localhost", "80"}},
+		{spec: "[::1]:8080:[fd00::1]:80", want: []string{"::1", "8080", "fd00::1", "80"}},
+		{spec: "[fe80::1%eth0]:22", want: []string{"fe80::1%eth0", "22"}},
+		{spec: "fd00::1", want: []string{"fd00", "", "1"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.spec, func(t *testing.T) {
+			if got := splitForward(tt.spec); !slices.Equal(got, tt.want) {
+				t.Errorf("splitForward(%q) = %q, want %q", tt.spec, got, tt.want)
+			}
+		})
+	}
+}
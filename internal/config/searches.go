@@ -0,0 +1,29 @@
+package config
+
+import "fmt"
+
+// SavedSearch is a named search query recalled from the TUI
+type SavedSearch struct {
+	Name        string `json:"name"`
+	Query       string `json:"query"`       // fuzzy search text, "" lists everything
+	Environment string `json:"environment"` // environment to switch to; "" keeps the current one
+	AllStates   bool   `json:"all_states"`  // list stopped and transitional instances too
+}
+
+// validateSavedSearches checks search names and environments
+func (c Config) validateSavedSearches() error {
+	seen := make(map[string]bool)
+	for i, search := range c.SavedSearches {
+		switch {
+		case search.Name == "":
+			return fmt.Errorf("%w: saved_searches[%d]: name is required", ErrConfigInvalid, i)
+		case seen[search.Name]:
+			return fmt.Errorf("%w: saved search %q is declared twice", ErrConfigInvalid, search.Name)
+		}
+		if _, ok := c.Environment(search.Environment); search.Environment != "" && search.Environment != Unclassified && !ok {
+			return fmt.Errorf("%w: saved search %q: unknown environment %q", ErrConfigInvalid, search.Name, search.Environment)
+		}
+		seen[search.Name] = true
+	}
+	return nil
+}
@@ -0,0 +1,31 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// Tmux layouts for connecting to several instances at once
+const (
+	TmuxTiled   = "tiled"   // one pane per instance in a single window
+	TmuxWindows = "windows" // one window per instance
+)
+
+// TmuxLayouts lists the valid tmux layouts
+var TmuxLayouts = []string{TmuxTiled, TmuxWindows}
+
+// TmuxLayout returns the configured tmux layout, TmuxTiled by default
+func (c Config) TmuxLayout() string {
+	if c.Tmux.Layout == "" {
+		return TmuxTiled
+	}
+	return c.Tmux.Layout
+}
+
+// validateTmux checks the tmux layout
+func (c Config) validateTmux() error {
+	if c.Tmux.Layout != "" && !slices.Contains(TmuxLayouts, c.Tmux.Layout) {
+		return fmt.Errorf("%w: tmux.layout: unknown layout %q", ErrConfigInvalid, c.Tmux.Layout)
+	}
+	return nil
+}